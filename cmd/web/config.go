@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sethvargo/go-envconfig"
+	"github.com/squee1945/pillar-service/pkg/service"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "<redacted>"
+
+// config is loaded from the defaults, an optional YAML (or JSON) file, then
+// environment variables. Fields tagged `redact:"true"` are masked by
+// --print-config.
+type config struct {
+	ProjectID string `yaml:"projectID" env:"PROJECT_ID"`
+	Region    string `yaml:"region" env:"REGION"`
+
+	KMSKeyName                 string `yaml:"kmsKeyName" env:"KMS_KEY_NAME"`
+	RunnerServiceAccount       string `yaml:"runnerServiceAccount" env:"RUNNER_SERVICE_ACCOUNT"`
+	PrepImage                  string `yaml:"prepImage" env:"PREP_IMAGE"`
+	PromptImage                string `yaml:"promptImage" env:"PROMPT_IMAGE"`
+	PromptBucket               string `yaml:"promptBucket" env:"PROMPT_BUCKET"`
+	GitHubAppID                int64  `yaml:"githubAppID" env:"GITHUB_APP_ID"`
+	GitHubWebhookSecretName    string `yaml:"githubWebhookSecretName" env:"GITHUB_WEBHOOK_SECRET_NAME" redact:"true"`
+	GitHubPrivateKeySecretName string `yaml:"githubPrivateKeySecretName" env:"GITHUB_PRIVATE_KEY_SECRET_NAME" redact:"true"`
+	GeminiApiKeySecretName     string `yaml:"geminiApiKeySecretName" env:"GEMINI_API_KEY_SECRET_NAME" redact:"true"`
+
+	Port           string        `yaml:"port" env:"PORT,default=8080"`
+	SecretCacheTTL time.Duration `yaml:"secretCacheTTL" env:"SECRET_CACHE_TTL,default=1m"`
+
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"READ_HEADER_TIMEOUT,default=10s"`
+	ReadTimeout       time.Duration `yaml:"readTimeout" env:"READ_TIMEOUT,default=30s"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout" env:"WRITE_TIMEOUT,default=5m"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout" env:"IDLE_TIMEOUT,default=2m"`
+	// Cloud Run sends SIGKILL 10s after SIGTERM; in-flight requests get
+	// ShutdownTimeout, then pending work gets FlushTimeout.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT,default=5s"`
+	FlushTimeout    time.Duration `yaml:"flushTimeout" env:"FLUSH_TIMEOUT,default=4s"`
+
+	// Admin API authentication; see service.Config.
+	AdminAudience   string   `yaml:"adminAudience" env:"ADMIN_AUDIENCE"`
+	AdminPrincipals []string `yaml:"adminPrincipals" env:"ADMIN_PRINCIPALS"`
+	AdminAuthBypass bool     `yaml:"adminAuthBypass" env:"ADMIN_AUTH_BYPASS"`
+
+	JobHistorySize int `yaml:"jobHistorySize" env:"JOB_HISTORY_SIZE,default=1000"`
+
+	// Skips verifying at startup that the GitHub app has the permissions every
+	// trigger needs; useful for local runs without GitHub access.
+	SkipPermissionCheck bool `yaml:"skipPermissionCheck" env:"SKIP_PERMISSION_CHECK"`
+
+	// Disables onboarding pull requests when the app is installed on a
+	// repository.
+	SkipOnboarding bool `yaml:"skipOnboarding" env:"SKIP_ONBOARDING"`
+
+	// How long to collect releases before upgrading a dependent.
+	ReleaseBatchWindow time.Duration `yaml:"releaseBatchWindow" env:"RELEASE_BATCH_WINDOW,default=5m"`
+
+	// Prompt template overrides; see service.Config.
+	PromptTemplatesURL   string        `yaml:"promptTemplatesURL" env:"PROMPT_TEMPLATES_URL"`
+	EnableRepoPrompts    bool          `yaml:"enableRepoPrompts" env:"ENABLE_REPO_PROMPTS"`
+	PromptReloadInterval time.Duration `yaml:"promptReloadInterval" env:"PROMPT_RELOAD_INTERVAL,default=1m"`
+	PromptBudget         int           `yaml:"promptBudget" env:"PROMPT_BUDGET,default=100000"`
+
+	// A "SubBuild" is a build that is configured and created by the runner.
+	SubBuildServiceAccount   string `yaml:"subBuildServiceAccount" env:"SUB_BUILD_SERVICE_ACCOUNT"`
+	SubBuildLogsBucket       string `yaml:"subBuildLogsBucket" env:"SUB_BUILD_LOGS_BUCKET"`
+	SubBuildTestOutputBucket string `yaml:"subBuildTestOutputBucket" env:"SUB_BUILD_TEST_OUTPUT_BUCKET"`
+	SubBuildGoRepository     string `yaml:"subBuildGoRepository" env:"SUB_BUILD_GO_REPOSITORY"`
+	SubBuildPolicyURL        string `yaml:"subBuildPolicyURL" env:"SUB_BUILD_POLICY_URL"`
+
+	// Optional Artifact Registry repositories for the other ecosystems'
+	// sub-build artifacts; sub-builds cannot upload artifacts of an ecosystem
+	// without one.
+	SubBuildMavenRepository  string `yaml:"subBuildMavenRepository" env:"SUB_BUILD_MAVEN_REPOSITORY"`
+	SubBuildNpmRepository    string `yaml:"subBuildNpmRepository" env:"SUB_BUILD_NPM_REPOSITORY"`
+	SubBuildPythonRepository string `yaml:"subBuildPythonRepository" env:"SUB_BUILD_PYTHON_REPOSITORY"`
+}
+
+// loadConfig starts from the defaults in the env tags, applies the config file
+// at path (if non-empty), then the environment variables that are set.
+// Environment variables always win, and values in the file, including zero
+// values, win over defaults.
+func loadConfig(ctx context.Context, path string) (config, error) {
+	return loadConfigWith(ctx, path, envconfig.OsLookuper())
+}
+
+func loadConfigWith(ctx context.Context, path string, lookuper envconfig.Lookuper) (config, error) {
+	var c config
+	if err := envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target:   &c,
+		Lookuper: envconfig.MapLookuper(nil),
+	}); err != nil {
+		return config{}, fmt.Errorf("applying defaults: %w", err)
+	}
+
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return config{}, fmt.Errorf("reading config file: %w", err)
+		}
+		// JSON is a subset of YAML, so a single decoder handles both formats.
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) { // An empty file is fine.
+			return config{}, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+
+	// envconfig would also re-apply the defaults over zero values from the
+	// file, so the variables are processed into a copy and only the fields
+	// whose variable is set are taken from it.
+	var env config
+	if err := envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target:   &env,
+		Lookuper: lookuper,
+	}); err != nil {
+		return config{}, fmt.Errorf("processing environment variables: %w", err)
+	}
+	cv, ev := reflect.ValueOf(&c).Elem(), reflect.ValueOf(env)
+	for i := range cv.NumField() {
+		name, _, _ := strings.Cut(cv.Type().Field(i).Tag.Get("env"), ",")
+		if _, ok := lookuper.Lookup(name); ok {
+			cv.Field(i).Set(ev.Field(i))
+		}
+	}
+	return c, nil
+}
+
+// validate checks the settings used by this command; the service settings are
+// checked by service.Config.Validate.
+func (c config) validate() error {
+	var errs []error
+	if c.Port == "" {
+		errs = append(errs, errors.New("port (PORT) is required"))
+	}
+	if c.SecretCacheTTL < 0 {
+		errs = append(errs, errors.New("secretCacheTTL (SECRET_CACHE_TTL) must be non-negative"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdownTimeout (SHUTDOWN_TIMEOUT) must be positive"))
+	}
+	if c.FlushTimeout <= 0 {
+		errs = append(errs, errors.New("flushTimeout (FLUSH_TIMEOUT) must be positive"))
+	}
+	if c.JobHistorySize <= 0 {
+		errs = append(errs, errors.New("jobHistorySize (JOB_HISTORY_SIZE) must be positive"))
+	}
+	if err := c.serviceConfig().Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// serviceConfig returns the service settings; the caller adds the logger,
+// secret accessor and job store.
+func (c config) serviceConfig() service.Config {
+	return service.Config{
+		AppID:                    c.GitHubAppID,
+		WebhookSecretName:        c.GitHubWebhookSecretName,
+		AppPrivateKeySecretName:  c.GitHubPrivateKeySecretName,
+		ProjectID:                c.ProjectID,
+		Region:                   c.Region,
+		PromptBucket:             c.PromptBucket,
+		KMSKeyName:               c.KMSKeyName,
+		RunnerServiceAccount:     c.RunnerServiceAccount,
+		PrepImage:                c.PrepImage,
+		PromptImage:              c.PromptImage,
+		GeminiAPIKeySecretName:   c.GeminiApiKeySecretName,
+		SubBuildServiceAccount:   c.SubBuildServiceAccount,
+		SubBuildLogsBucket:       c.SubBuildLogsBucket,
+		SubBuildTestOutputBucket: c.SubBuildTestOutputBucket,
+		SubBuildGoRepository:     c.SubBuildGoRepository,
+		SubBuildMavenRepository:  c.SubBuildMavenRepository,
+		SubBuildNpmRepository:    c.SubBuildNpmRepository,
+		SubBuildPythonRepository: c.SubBuildPythonRepository,
+		SubBuildPolicyURL:        c.SubBuildPolicyURL,
+		AdminAudience:            c.AdminAudience,
+		AdminPrincipals:          c.AdminPrincipals,
+		AdminAuthBypass:          c.AdminAuthBypass,
+		SkipOnboarding:           c.SkipOnboarding,
+		ReleaseBatchWindow:       c.ReleaseBatchWindow,
+		PromptTemplatesURL:       c.PromptTemplatesURL,
+		EnableRepoPrompts:        c.EnableRepoPrompts,
+		PromptReloadInterval:     c.PromptReloadInterval,
+		PromptBudget:             c.PromptBudget,
+	}
+}
+
+// redacted returns a YAML rendering of the config with sensitive fields
+// masked.
+func (c config) redacted() (string, error) {
+	v := reflect.ValueOf(&c).Elem()
+	t := v.Type()
+	for i := range t.NumField() {
+		if t.Field(i).Tag.Get("redact") != "true" {
+			continue
+		}
+		if f := v.Field(i); f.Kind() == reflect.String && f.String() != "" {
+			f.SetString(redacted)
+		}
+	}
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("marshalling config: %w", err)
+	}
+	return string(b), nil
+}
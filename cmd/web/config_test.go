@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sethvargo/go-envconfig"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		check func(t *testing.T, c config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, c config) {
+				if c.Port != "8080" || c.PromptBudget != 100000 || c.ReleaseBatchWindow != 5*time.Minute {
+					t.Errorf("got port %q, promptBudget %d, releaseBatchWindow %s; want the defaults", c.Port, c.PromptBudget, c.ReleaseBatchWindow)
+				}
+			},
+		},
+		{
+			name: "file overrides defaults",
+			file: "port: \"9090\"\nprojectID: file-project\n",
+			check: func(t *testing.T, c config) {
+				if c.Port != "9090" || c.ProjectID != "file-project" {
+					t.Errorf("got port %q, projectID %q; want the file's", c.Port, c.ProjectID)
+				}
+			},
+		},
+		{
+			name: "zero values in the file are kept",
+			file: "promptBudget: 0\nreleaseBatchWindow: 0s\n",
+			check: func(t *testing.T, c config) {
+				if c.PromptBudget != 0 || c.ReleaseBatchWindow != 0 {
+					t.Errorf("got promptBudget %d, releaseBatchWindow %s; want 0", c.PromptBudget, c.ReleaseBatchWindow)
+				}
+			},
+		},
+		{
+			name: "env overrides file",
+			file: "port: \"9090\"\nprojectID: file-project\nregion: us-east1\n",
+			env:  map[string]string{"PROJECT_ID": "env-project", "PROMPT_BUDGET": "0"},
+			check: func(t *testing.T, c config) {
+				if c.ProjectID != "env-project" || c.PromptBudget != 0 {
+					t.Errorf("got projectID %q, promptBudget %d; want the environment's", c.ProjectID, c.PromptBudget)
+				}
+				if c.Port != "9090" || c.Region != "us-east1" {
+					t.Errorf("got port %q, region %q; want the file's", c.Port, c.Region)
+				}
+			},
+		},
+		{
+			name: "JSON file",
+			file: `{"adminPrincipals": ["a@example.com"], "shutdownTimeout": "3s"}`,
+			env:  map[string]string{"ADMIN_PRINCIPALS": "b@example.com,c@example.com"},
+			check: func(t *testing.T, c config) {
+				if strings.Join(c.AdminPrincipals, ",") != "b@example.com,c@example.com" || c.ShutdownTimeout != 3*time.Second {
+					t.Errorf("got adminPrincipals %q, shutdownTimeout %s", c.AdminPrincipals, c.ShutdownTimeout)
+				}
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var path string
+			if tc.file != "" {
+				path = filepath.Join(t.TempDir(), "config.yaml")
+				if err := os.WriteFile(path, []byte(tc.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c, err := loadConfigWith(context.Background(), path, envconfig.MapLookuper(tc.env))
+			if err != nil {
+				t.Fatalf("loadConfigWith() = %v", err)
+			}
+			tc.check(t, c)
+		})
+	}
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("projectId: typo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfigWith(context.Background(), path, envconfig.MapLookuper(nil)); err == nil {
+		t.Error("loadConfigWith() = nil, want an error for an unknown field")
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	c := config{
+		ProjectID:                  "proj",
+		GitHubWebhookSecretName:    "projects/proj/secrets/webhook",
+		GitHubPrivateKeySecretName: "projects/proj/secrets/key",
+	}
+	out, err := c.redacted()
+	if err != nil {
+		t.Fatalf("redacted() = %v", err)
+	}
+	for _, secret := range []string{"secrets/webhook", "secrets/key"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("redacted() contains %q:\n%s", secret, out)
+		}
+	}
+	for _, want := range []string{"projectID: proj", "githubWebhookSecretName: " + redacted, "githubPrivateKeySecretName: " + redacted, "geminiApiKeySecretName: \"\""} {
+		if !strings.Contains(out, want) {
+			t.Errorf("redacted() does not contain %q:\n%s", want, out)
+		}
+	}
+	if c.GitHubWebhookSecretName != "projects/proj/secrets/webhook" {
+		t.Errorf("redacted() modified the config")
+	}
+}
@@ -2,48 +2,52 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
-	"time"
+	"syscall"
 
-	"github.com/sethvargo/go-envconfig"
+	"github.com/squee1945/pillar-service/pkg/jobs"
 	"github.com/squee1945/pillar-service/pkg/logger"
 	"github.com/squee1945/pillar-service/pkg/secrets"
 	"github.com/squee1945/pillar-service/pkg/service"
 )
 
-type config struct {
-	ProjectID string `env:"PROJECT_ID,required"`
-	Region    string `env:"REGION,required"`
-
-	KMSKeyName                 string `env:"KMS_KEY_NAME,required"`
-	RunnerServiceAccount       string `env:"RUNNER_SERVICE_ACCOUNT,required"`
-	PrepImage                  string `env:"PREP_IMAGE,required"`
-	PromptImage                string `env:"PROMPT_IMAGE,required"`
-	PromptBucket               string `env:"PROMPT_BUCKET,required"`
-	GitHubAppID                int64  `env:"GITHUB_APP_ID,required"`
-	GitHubWebhookSecretName    string `env:"GITHUB_WEBHOOK_SECRET_NAME,required"`
-	GitHubPrivateKeySecretName string `env:"GITHUB_PRIVATE_KEY_SECRET_NAME,required"`
-	GeminiApiKeySecretName     string `env:"GEMINI_API_KEY_SECRET_NAME,required"`
-
-	Port           string        `env:"PORT,default=8080"`
-	SecretCacheTTL time.Duration `env:"SECRET_CACHE_TTL,default=1m"`
-
-	// A "SubBuild" is a build that is configured and created by the runner.
-	SubBuildServiceAccount   string `env:"SUB_BUILD_SERVICE_ACCOUNT",required`
-	SubBuildLogsBucket       string `env:"SUB_BUILD_LOGS_BUCKET",required`
-	SubBuildTestOutputBucket string `env:"SUB_BUILD_TEST_OUTPUT_BUCKET",required`
-	SubBuildGoRepository     string `env:"SUB_BUILD_GO_REPOSITORY",required`
-}
+var (
+	configFile  = flag.String("config", os.Getenv("CONFIG_FILE"), "Optional YAML or JSON config file; environment variables take precedence")
+	printConfig = flag.Bool("print-config", false, "Print the effective config (secrets redacted) and exit")
+)
 
 func main() {
 	ctx := context.Background()
 	log := logger.New()
 
-	var c config
-	if err := envconfig.Process(ctx, &c); err != nil {
-		fail(ctx, log, "processing environment variables: %v", err)
+	flag.Parse()
+
+	c, err := loadConfig(ctx, *configFile)
+	if err != nil {
+		fail(ctx, log, "loading config: %v", err)
+	}
+
+	if *printConfig {
+		out, err := c.redacted()
+		if err != nil {
+			fail(ctx, log, "printing config: %v", err)
+		}
+		fmt.Print(out)
+		if err := c.validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "\nconfig is invalid:\n%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := c.validate(); err != nil {
+		fail(ctx, log, "invalid config:\n%v", err)
 	}
 
 	secretAccessor, err := secrets.New(ctx, c.SecretCacheTTL)
@@ -52,36 +56,68 @@ func main() {
 	}
 	defer secretAccessor.Close()
 
-	serverConfig := service.Config{
-		Log:                      log,
-		AppID:                    c.GitHubAppID,
-		Secrets:                  secretAccessor,
-		WebhookSecretName:        c.GitHubWebhookSecretName,
-		AppPrivateKeySecretName:  c.GitHubPrivateKeySecretName,
-		ProjectID:                c.ProjectID,
-		Region:                   c.Region,
-		PromptBucket:             c.PromptBucket,
-		KMSKeyName:               c.KMSKeyName,
-		RunnerServiceAccount:     c.RunnerServiceAccount,
-		PrepImage:                c.PrepImage,
-		PromptImage:              c.PromptImage,
-		GeminiAPIKeySecretName:   c.GeminiApiKeySecretName,
-		SubBuildServiceAccount:   c.SubBuildServiceAccount,
-		SubBuildLogsBucket:       c.SubBuildLogsBucket,
-		SubBuildTestOutputBucket: c.SubBuildTestOutputBucket,
-		SubBuildGoRepository:     c.SubBuildGoRepository,
-	}
+	serverConfig := c.serviceConfig()
+	serverConfig.Log = log
+	serverConfig.Secrets = secretAccessor
+	serverConfig.Jobs = jobs.NewMemory(c.JobHistorySize)
 
 	server, err := service.New(ctx, serverConfig)
 	if err != nil {
 		fail(ctx, log, "creating service: %v", err)
 	}
 
-	log.Info(ctx, strings.Repeat("=", 120))
-	log.Info(ctx, "Starting server on port "+c.Port)
-	if err := http.ListenAndServe(":"+c.Port, server.Handler()); err != nil {
-		fail(ctx, log, "server failed: %v", err)
+	if c.SkipPermissionCheck {
+		log.Warn(ctx, "Skipping GitHub app permission check")
+	} else if err := server.CheckPermissions(ctx); err != nil {
+		fail(ctx, log, "checking GitHub app permissions:\n%v", err)
 	}
+
+	httpServer := &http.Server{
+		Addr:              ":" + c.Port,
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+	}
+
+	sigCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Info(ctx, "%s", strings.Repeat("=", 120))
+		log.Info(ctx, "Starting server on port %s", c.Port)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			fail(ctx, log, "server failed: %v", err)
+		}
+		return
+	case <-sigCtx.Done():
+		stop()
+	}
+
+	// Stop advertising readiness, then let in-flight handlers (e.g., a fork in
+	// progress) complete before the instance is torn down. Pending work is
+	// flushed afterwards, with its own deadline, so that it cannot use up the
+	// handlers' time.
+	log.Info(ctx, "Received shutdown signal, draining for up to %s", c.ShutdownTimeout)
+	server.Drain()
+	shutdownCtx, cancel := context.WithTimeout(ctx, c.ShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Error(ctx, "Graceful shutdown failed: %v", err)
+	} else {
+		log.Info(ctx, "Server shut down cleanly")
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(ctx, c.FlushTimeout)
+	defer cancelFlush()
+	server.Flush(flushCtx)
 }
 
 func fail(ctx context.Context, log logger.L, format string, args ...any) {
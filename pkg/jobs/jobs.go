@@ -0,0 +1,172 @@
+// Package jobs records the runner jobs launched by the service so they can be
+// inspected, retried and cancelled.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/squee1945/pillar-service/pkg/runner"
+)
+
+var ErrNotFound = errors.New("job not found")
+
+type Status string
+
+const (
+	StatusPending   Status = "PENDING"
+	StatusRunning   Status = "RUNNING"
+	StatusSucceeded Status = "SUCCEEDED"
+	StatusFailed    Status = "FAILED"
+	StatusCancelled Status = "CANCELLED"
+	// StatusCancelling jobs have a cancellation of their build in progress.
+	StatusCancelling Status = "CANCELLING"
+	// StatusRefused jobs were never run, because their prompt failed the
+	// prompt injection guardrail.
+	StatusRefused Status = "REFUSED"
+)
+
+// Terminal reports whether the status will no longer change on its own.
+func (s Status) Terminal() bool {
+	switch s {
+	case StatusSucceeded, StatusFailed, StatusCancelled, StatusRefused:
+		return true
+	}
+	return false
+}
+
+type Job struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Trigger describes what launched the job, e.g. "release/published", and
+	// TriggerURL links to the GitHub release, issue or pull request involved.
+	Trigger        string `json:"trigger"`
+	TriggerURL     string `json:"triggerURL,omitempty"`
+	DeliveryID     string `json:"deliveryID,omitempty"`
+	InstallationID int64  `json:"installationID"`
+	RepoID         int64  `json:"repoID"`
+	Owner          string `json:"owner"`
+	Repo           string `json:"repo"`
+
+	Status   Status   `json:"status"`
+	Error    string   `json:"error,omitempty"`
+	Attempts int      `json:"attempts"`
+	BuildIDs []string `json:"buildIDs,omitempty"`
+
+	Prompt    string     `json:"prompt"`
+	Guardrail *Guardrail `json:"guardrail,omitempty"`
+	// Settings is the runner config with credentials redacted.
+	Settings runner.Config `json:"settings"`
+}
+
+// FullName returns the "<owner>/<repo>" of the target repository.
+func (j *Job) FullName() string {
+	return j.Owner + "/" + j.Repo
+}
+
+// LatestBuildID returns the most recent runner build, or "" if none.
+func (j *Job) LatestBuildID() string {
+	if len(j.BuildIDs) == 0 {
+		return ""
+	}
+	return j.BuildIDs[len(j.BuildIDs)-1]
+}
+
+// Guardrail records how the untrusted content in a job's prompt was screened
+// for prompt injection.
+type Guardrail struct {
+	Policy   string    `json:"policy"` // The repository's policy: off, flag or refuse.
+	Decision Decision  `json:"decision"`
+	Findings []Finding `json:"findings,omitempty"`
+}
+
+type Decision string
+
+const (
+	DecisionUnscanned Decision = "UNSCANNED"
+	DecisionClean     Decision = "CLEAN"
+	DecisionFlagged   Decision = "FLAGGED"
+	DecisionRefused   Decision = "REFUSED"
+)
+
+// Finding is a match of a known injection pattern in untrusted content.
+type Finding struct {
+	Content string `json:"content"` // The label of the untrusted block.
+	Pattern string `json:"pattern"`
+	Excerpt string `json:"excerpt"`
+}
+
+// Delivery records a webhook delivery and how it was handled.
+type Delivery struct {
+	ID         string    `json:"id"` // The X-GitHub-Delivery header.
+	ReceivedAt time.Time `json:"receivedAt"`
+	Event      string    `json:"event"`
+	Action     string    `json:"action,omitempty"`
+	Repo       string    `json:"repo,omitempty"`
+	Outcome    Outcome   `json:"outcome"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+type Outcome string
+
+const (
+	OutcomeHandled   Outcome = "HANDLED"
+	OutcomeUnhandled Outcome = "UNHANDLED"
+	OutcomeError     Outcome = "ERROR"
+)
+
+// Installation records a GitHub app installation and the repositories it can
+// access.
+type Installation struct {
+	ID        int64     `json:"id"`
+	Account   string    `json:"account"`
+	Suspended bool      `json:"suspended,omitempty"`
+	Repos     []Repo    `json:"repos"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type Repo struct {
+	ID       int64  `json:"id"`
+	FullName string `json:"fullName"` // "<owner>/<repo>".
+}
+
+type Filter struct {
+	Repo   string // "<owner>/<repo>"; empty matches all.
+	Status Status // Empty matches all.
+	Limit  int    // Zero means no limit.
+}
+
+func (f Filter) matches(j *Job) bool {
+	if f.Repo != "" && f.Repo != j.FullName() {
+		return false
+	}
+	if f.Status != "" && f.Status != j.Status {
+		return false
+	}
+	return true
+}
+
+// Store persists jobs. Implementations must be safe for concurrent use and
+// must return copies, so that callers cannot mutate stored jobs directly.
+type Store interface {
+	Create(ctx context.Context, j *Job) error
+	Get(ctx context.Context, id string) (*Job, error)
+	// Update applies fn to the stored job atomically.
+	Update(ctx context.Context, id string, fn func(*Job) error) (*Job, error)
+	// List returns jobs matching f, newest first.
+	List(ctx context.Context, f Filter) ([]*Job, error)
+
+	AddDelivery(ctx context.Context, d *Delivery) error
+	// ListDeliveries returns up to limit deliveries, newest first.
+	ListDeliveries(ctx context.Context, limit int) ([]*Delivery, error)
+
+	// UpdateInstallation applies fn to the stored installation atomically,
+	// creating it first if it does not exist.
+	UpdateInstallation(ctx context.Context, id int64, fn func(*Installation) error) (*Installation, error)
+	DeleteInstallation(ctx context.Context, id int64) error
+	// ListInstallations returns all installations, ordered by ID.
+	ListInstallations(ctx context.Context) ([]*Installation, error)
+}
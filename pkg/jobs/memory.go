@@ -0,0 +1,195 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+const defaultMaxJobs = 1000
+
+// Memory is an in-process Store intended for local runs and single-instance
+// deployments. The oldest jobs (and deliveries) are evicted once maxJobs is
+// exceeded.
+type Memory struct {
+	maxJobs int
+
+	mu         sync.Mutex
+	jobs       map[string]*Job
+	order      []string    // Job IDs, oldest first.
+	deliveries []*Delivery // Oldest first.
+
+	installations map[int64]*Installation
+}
+
+var _ Store = (*Memory)(nil)
+
+func NewMemory(maxJobs int) *Memory {
+	if maxJobs <= 0 {
+		maxJobs = defaultMaxJobs
+	}
+	return &Memory{
+		maxJobs:       maxJobs,
+		jobs:          make(map[string]*Job),
+		installations: make(map[int64]*Installation),
+	}
+}
+
+func (m *Memory) Create(_ context.Context, j *Job) error {
+	if j.ID == "" {
+		return fmt.Errorf("job ID must be set")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.jobs[j.ID]; ok {
+		return fmt.Errorf("job %s already exists", j.ID)
+	}
+	now := time.Now()
+	c := clone(j)
+	c.CreatedAt, c.UpdatedAt = now, now
+	m.jobs[c.ID] = c
+	m.order = append(m.order, c.ID)
+
+	for len(m.order) > m.maxJobs {
+		delete(m.jobs, m.order[0])
+		m.order = m.order[1:]
+	}
+	return nil
+}
+
+func (m *Memory) Get(_ context.Context, id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(j), nil
+}
+
+func (m *Memory) Update(_ context.Context, id string, fn func(*Job) error) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := clone(j)
+	if err := fn(c); err != nil {
+		return nil, err
+	}
+	c.ID, c.CreatedAt, c.UpdatedAt = j.ID, j.CreatedAt, time.Now()
+	m.jobs[id] = c
+	return clone(c), nil
+}
+
+func (m *Memory) List(_ context.Context, f Filter) ([]*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []*Job
+	for _, id := range slices.Backward(m.order) {
+		j := m.jobs[id]
+		if !f.matches(j) {
+			continue
+		}
+		out = append(out, clone(j))
+		if f.Limit > 0 && len(out) >= f.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func clone(j *Job) *Job {
+	c := *j
+	c.BuildIDs = slices.Clone(j.BuildIDs)
+	c.Settings.DevHelperIncludeTools = slices.Clone(j.Settings.DevHelperIncludeTools)
+	c.Settings.DevHelperExcludeTools = slices.Clone(j.Settings.DevHelperExcludeTools)
+	c.Settings.GithubIncludeTools = slices.Clone(j.Settings.GithubIncludeTools)
+	c.Settings.GithubExcludeTools = slices.Clone(j.Settings.GithubExcludeTools)
+	if j.Guardrail != nil {
+		g := *j.Guardrail
+		g.Findings = slices.Clone(j.Guardrail.Findings)
+		c.Guardrail = &g
+	}
+	return &c
+}
+
+func (m *Memory) AddDelivery(_ context.Context, d *Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := *d
+	m.deliveries = append(m.deliveries, &c)
+	if over := len(m.deliveries) - m.maxJobs; over > 0 {
+		m.deliveries = m.deliveries[over:]
+	}
+	return nil
+}
+
+func (m *Memory) ListDeliveries(_ context.Context, limit int) ([]*Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []*Delivery
+	for _, d := range slices.Backward(m.deliveries) {
+		c := *d
+		out = append(out, &c)
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (m *Memory) UpdateInstallation(_ context.Context, id int64, fn func(*Installation) error) (*Installation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &Installation{ID: id}
+	if inst, ok := m.installations[id]; ok {
+		c = cloneInstallation(inst)
+	}
+	if err := fn(c); err != nil {
+		return nil, err
+	}
+	c.ID, c.UpdatedAt = id, time.Now()
+	m.installations[id] = c
+	return cloneInstallation(c), nil
+}
+
+func (m *Memory) DeleteInstallation(_ context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.installations[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.installations, id)
+	return nil
+}
+
+func (m *Memory) ListInstallations(_ context.Context) ([]*Installation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []*Installation
+	for _, id := range slices.Sorted(maps.Keys(m.installations)) {
+		out = append(out, cloneInstallation(m.installations[id]))
+	}
+	return out, nil
+}
+
+func cloneInstallation(inst *Installation) *Installation {
+	c := *inst
+	c.Repos = slices.Clone(inst.Repos)
+	return &c
+}
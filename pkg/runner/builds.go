@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cloudbuild "cloud.google.com/go/cloudbuild/apiv1/v2"
+	"cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// maxBuildsPerList caps the build IDs in the filter of one ListBuilds request.
+const maxBuildsPerList = 50
+
+func cloudBuildClient(ctx context.Context, region string) (*cloudbuild.Client, error) {
+	endpoint := fmt.Sprintf("%s-cloudbuild.googleapis.com:443", region)
+	client, err := cloudbuild.NewClient(ctx, option.WithEndpoint(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("creating Cloud Build client: %v", err)
+	}
+	return client, nil
+}
+
+func buildName(projectID, region, buildID string) string {
+	return fmt.Sprintf("projects/%s/locations/%s/builds/%s", projectID, region, buildID)
+}
+
+// GetBuild fetches a runner build.
+func GetBuild(ctx context.Context, projectID, region, buildID string) (*cloudbuildpb.Build, error) {
+	client, err := cloudBuildClient(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	build, err := client.GetBuild(ctx, &cloudbuildpb.GetBuildRequest{
+		Name:      buildName(projectID, region, buildID),
+		ProjectId: projectID,
+		Id:        buildID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting build %s: %w", buildID, err)
+	}
+	return build, nil
+}
+
+// GetBuilds fetches runner builds by ID, with one client and a filtered
+// ListBuilds request per maxBuildsPerList IDs. Builds that are not found are
+// missing from the result.
+func GetBuilds(ctx context.Context, projectID, region string, buildIDs []string) (map[string]*cloudbuildpb.Build, error) {
+	builds := make(map[string]*cloudbuildpb.Build)
+	if len(buildIDs) == 0 {
+		return builds, nil
+	}
+
+	client, err := cloudBuildClient(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	for len(buildIDs) > 0 {
+		chunk := buildIDs[:min(len(buildIDs), maxBuildsPerList)]
+		buildIDs = buildIDs[len(chunk):]
+
+		terms := make([]string, len(chunk))
+		for i, id := range chunk {
+			terms[i] = fmt.Sprintf("build_id=%q", id)
+		}
+		it := client.ListBuilds(ctx, &cloudbuildpb.ListBuildsRequest{
+			Parent:    fmt.Sprintf("projects/%s/locations/%s", projectID, region),
+			ProjectId: projectID,
+			Filter:    strings.Join(terms, " OR "),
+			PageSize:  int32(len(chunk)),
+		})
+		for {
+			build, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("listing builds: %w", err)
+			}
+			builds[build.GetId()] = build
+		}
+	}
+	return builds, nil
+}
+
+// CancelBuild cancels a runner build that is queued or in progress.
+func CancelBuild(ctx context.Context, projectID, region, buildID string) error {
+	client, err := cloudBuildClient(ctx, region)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, err = client.CancelBuild(ctx, &cloudbuildpb.CancelBuildRequest{
+		Name:      buildName(projectID, region, buildID),
+		ProjectId: projectID,
+		Id:        buildID,
+	})
+	if err != nil {
+		return fmt.Errorf("cancelling build %s: %w", buildID, err)
+	}
+	return nil
+}
+
+// LogsURL returns the Cloud Console URL for a build's logs.
+func LogsURL(projectID, region, buildID string) string {
+	return fmt.Sprintf("https://console.cloud.google.com/cloud-build/builds;region=%s/%s?project=%s", region, buildID, projectID)
+}
@@ -1,14 +1,16 @@
 package runner
 
 import (
-	"fmt"
+	"errors"
 	"time"
 
 	"github.com/squee1945/pillar-service/pkg/logger"
 )
 
+const redacted = "<redacted>"
+
 type Config struct {
-	Log            logger.L
+	Log            logger.L `json:"-"`
 	ProjectID      string
 	Region         string
 	PromptBucket   string
@@ -32,6 +34,13 @@ type Config struct {
 	SubBuildGoRepository     string
 
 	// Optional config
+	// Artifact Registry repositories for sub-build artifacts of ecosystems
+	// other than Go.
+	SubBuildMavenRepository  string
+	SubBuildNpmRepository    string
+	SubBuildPythonRepository string
+
+	SubBuildPolicyURL     string // gs:// URL of the sub-build policy; see devhelpermcp.
 	RunnerTimeout         time.Duration
 	GeminiMaxSessionTurns int
 	DevHelperIncludeTools []string
@@ -43,53 +52,66 @@ type Config struct {
 }
 
 func (c Config) validate() error {
+	var errs []error
 	if c.ProjectID == "" {
-		return fmt.Errorf("ProjectID must be set")
+		errs = append(errs, errors.New("ProjectID must be set"))
 	}
 	if c.Region == "" {
-		return fmt.Errorf("Region must be set")
+		errs = append(errs, errors.New("Region must be set"))
 	}
 	if c.PromptBucket == "" {
-		return fmt.Errorf("PromptBucket must be set")
+		errs = append(errs, errors.New("PromptBucket must be set"))
 	}
 	if c.KMSKeyName == "" {
-		return fmt.Errorf("KMSKeyName must be set")
+		errs = append(errs, errors.New("KMSKeyName must be set"))
 	}
 	if c.ServiceAccount == "" {
-		return fmt.Errorf("ServiceAccount must be set")
+		errs = append(errs, errors.New("ServiceAccount must be set"))
 	}
 	if c.PrepImage == "" {
-		return fmt.Errorf("PrepImage must be set")
+		errs = append(errs, errors.New("PrepImage must be set"))
 	}
 	if c.GitHubToken == "" {
-		return fmt.Errorf("GitHubToken must be set")
+		errs = append(errs, errors.New("GitHubToken must be set"))
 	}
 	if c.Owner == "" {
-		return fmt.Errorf("Owner must be set")
+		errs = append(errs, errors.New("Owner must be set"))
 	}
 	if c.Repo == "" {
-		return fmt.Errorf("Repo must be set")
+		errs = append(errs, errors.New("Repo must be set"))
 	}
 	if c.DefaultBranch == "" {
-		return fmt.Errorf("DefaultBranch must be set")
+		errs = append(errs, errors.New("DefaultBranch must be set"))
 	}
 	if c.DevBranch == "" {
-		return fmt.Errorf("DevBranch must be set")
+		errs = append(errs, errors.New("DevBranch must be set"))
 	}
 	if c.PromptImage == "" {
-		return fmt.Errorf("PromptImage must be set")
+		errs = append(errs, errors.New("PromptImage must be set"))
 	}
 	if c.SubBuildServiceAccount == "" {
-		return fmt.Errorf("SubBuildServiceAccount must be set")
+		errs = append(errs, errors.New("SubBuildServiceAccount must be set"))
 	}
 	if c.SubBuildLogsBucket == "" {
-		return fmt.Errorf("SubBuildLogsBucket must be set")
+		errs = append(errs, errors.New("SubBuildLogsBucket must be set"))
 	}
 	if c.SubBuildTestOutputBucket == "" {
-		return fmt.Errorf("SubBuildTestOutputBucket must be set")
+		errs = append(errs, errors.New("SubBuildTestOutputBucket must be set"))
 	}
 	if c.SubBuildGoRepository == "" {
-		return fmt.Errorf("SubBuildGoRepository must be set")
+		errs = append(errs, errors.New("SubBuildGoRepository must be set"))
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of the config with credentials removed, suitable for
+// logging or display.
+func (c Config) Redacted() Config {
+	if c.GitHubToken != "" {
+		c.GitHubToken = redacted
+	}
+	if c.GeminiAPIKey != "" {
+		c.GeminiAPIKey = redacted
 	}
-	return nil
+	return c
 }
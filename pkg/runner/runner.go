@@ -6,17 +6,18 @@ import (
 	"fmt"
 	"time"
 
-	cloudbuild "cloud.google.com/go/cloudbuild/apiv1/v2"
 	"cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
 	"github.com/google/uuid"
-	"google.golang.org/api/option"
 	"google.golang.org/protobuf/types/known/durationpb"
 )
 
+// DefaultRunnerTimeout is the runner build's timeout if Config.RunnerTimeout
+// is not set.
+const DefaultRunnerTimeout = 20 * time.Minute
+
 const (
 	gcsUploadTimeout = 30 * time.Second
 
-	defaultRunnerTimeout         = 20 * time.Minute
 	defaultMCPToolTimeout        = 2 * time.Minute
 	defaultGeminiMaxSessionTurns = 200
 
@@ -34,7 +35,7 @@ func New(ctx context.Context, cfg Config) (*R, error) {
 		return nil, err
 	}
 	if cfg.RunnerTimeout == 0 {
-		cfg.RunnerTimeout = defaultRunnerTimeout
+		cfg.RunnerTimeout = DefaultRunnerTimeout
 	}
 	if cfg.DevHelperMCPTimeout == 0 {
 		cfg.DevHelperMCPTimeout = defaultMCPToolTimeout
@@ -54,20 +55,22 @@ func New(ctx context.Context, cfg Config) (*R, error) {
 	return &R{Config: cfg, tag: uid.String()}, nil
 }
 
-func (r *R) Run(ctx context.Context) error {
+// Run creates the runner build and returns its Cloud Build ID. It does not wait
+// for the build to complete.
+func (r *R) Run(ctx context.Context) (string, error) {
 	encryptedGitHubToken, err := kmsEncrypt(ctx, r.KMSKeyName, []byte(r.GitHubToken))
 	if err != nil {
-		return fmt.Errorf("encrypting GitHub token: %v", err)
+		return "", fmt.Errorf("encrypting GitHub token: %v", err)
 	}
 
 	promptGCSPath, err := r.preparePrompt(ctx)
 	if err != nil {
-		return fmt.Errorf("preparing prompt: %v", err)
+		return "", fmt.Errorf("preparing prompt: %v", err)
 	}
 
 	settingsGCSPath, err := r.prepareSettings(ctx)
 	if err != nil {
-		return fmt.Errorf("preparing settings: %v", err)
+		return "", fmt.Errorf("preparing settings: %v", err)
 	}
 
 	build := cloudbuildpb.Build{
@@ -119,17 +122,16 @@ func (r *R) Run(ctx context.Context) error {
 
 		encryptedGeminiApiKey, err := kmsEncrypt(ctx, r.KMSKeyName, []byte(r.GeminiAPIKey))
 		if err != nil {
-			return fmt.Errorf("encrypting Gemini API key: %v", err)
+			return "", fmt.Errorf("encrypting Gemini API key: %v", err)
 		}
 		build.AvailableSecrets.Inline[0].EnvMap["GEMINI_API_KEY"] = encryptedGeminiApiKey
 	} else {
 		r.Log.Warn(ctx, "No prompt specified, skipping prompt step.")
 	}
 
-	endpoint := fmt.Sprintf("%s-cloudbuild.googleapis.com:443", r.Region)
-	client, err := cloudbuild.NewClient(ctx, option.WithEndpoint(endpoint))
+	client, err := cloudBuildClient(ctx, r.Region)
 	if err != nil {
-		return fmt.Errorf("creating Cloud Build client: %v", err)
+		return "", err
 	}
 	defer client.Close()
 
@@ -140,11 +142,17 @@ func (r *R) Run(ctx context.Context) error {
 
 	op, err := client.CreateBuild(ctx, req)
 	if err != nil {
-		return fmt.Errorf("creating Cloud Build build: %v", err)
+		return "", fmt.Errorf("creating Cloud Build build: %v", err)
+	}
+
+	metadata, err := op.Metadata()
+	if err != nil {
+		return "", fmt.Errorf("getting operation metadata: %v", err)
 	}
+	buildID := metadata.GetBuild().GetId()
 
-	r.Log.Info(ctx, "Runner build created successfully, operation: %s", op.Name())
-	return nil
+	r.Log.Info(ctx, "Runner build %s created successfully, operation: %s", buildID, op.Name())
+	return buildID, nil
 }
 
 func (r *R) prepareSettings(ctx context.Context) (string, error) {
@@ -160,6 +168,13 @@ func (r *R) prepareSettings(ctx context.Context) (string, error) {
 					"--sub_build_service_account=" + r.SubBuildServiceAccount,
 					"--sub_build_logs_bucket=" + r.SubBuildLogsBucket,
 					"--sub_build_test_output_bucket=" + r.SubBuildTestOutputBucket,
+					"--sub_build_go_repository=" + r.SubBuildGoRepository,
+					"--sub_build_maven_repository=" + r.SubBuildMavenRepository,
+					"--sub_build_npm_repository=" + r.SubBuildNpmRepository,
+					"--sub_build_python_repository=" + r.SubBuildPythonRepository,
+					"--build_policy=" + r.SubBuildPolicyURL,
+					"--runner_tag=" + r.tag,
+					"--tool_timeout=" + r.DevHelperMCPTimeout.String(),
 				},
 				Timeout:      r.DevHelperMCPTimeout.Milliseconds(),
 				IncludeTools: r.DevHelperIncludeTools,
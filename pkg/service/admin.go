@@ -0,0 +1,225 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/squee1945/pillar-service/pkg/jobs"
+	"github.com/squee1945/pillar-service/pkg/runner"
+)
+
+const (
+	iapJWTHeader         = "X-Goog-IAP-JWT-Assertion"
+	defaultAdminJobLimit = 50
+	maxAdminJobLimit     = 500
+)
+
+func (s *Service) registerAdminHandlers(mux *http.ServeMux) {
+	mux.Handle("GET /admin/api/jobs", s.adminAuth(http.HandlerFunc(s.adminListJobsHandler)))
+	mux.Handle("GET /admin/api/jobs/{id}", s.adminAuth(http.HandlerFunc(s.adminGetJobHandler)))
+	mux.Handle("POST /admin/api/jobs/{id}/retry", s.adminAuth(http.HandlerFunc(s.adminRetryJobHandler)))
+	mux.Handle("POST /admin/api/jobs/{id}/cancel", s.adminAuth(http.HandlerFunc(s.adminCancelJobHandler)))
+	mux.Handle("GET /admin/api/prompts", s.adminAuth(http.HandlerFunc(s.adminPromptsHandler)))
+	mux.Handle("POST /admin/api/prompts/reload", s.adminAuth(http.HandlerFunc(s.adminReloadPromptsHandler)))
+}
+
+// adminAuth verifies an IAP assertion or an OIDC bearer token for the
+// configured audience, and optionally restricts access to a set of principals.
+func (s *Service) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if s.AdminAuthBypass {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.idTokenValidator == nil {
+			s.clientError(w, r, http.StatusForbidden, "admin API is not configured")
+			return
+		}
+
+		token := r.Header.Get(iapJWTHeader)
+		if token == "" {
+			token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if token == "" {
+			s.clientError(w, r, http.StatusUnauthorized, "missing credentials")
+			return
+		}
+
+		payload, err := s.idTokenValidator.Validate(ctx, token, s.AdminAudience)
+		if err != nil {
+			s.clientError(w, r, http.StatusUnauthorized, "invalid token: %v", err)
+			return
+		}
+		email, _ := payload.Claims["email"].(string)
+		if len(s.AdminPrincipals) > 0 && !slices.Contains(s.AdminPrincipals, email) {
+			s.clientError(w, r, http.StatusForbidden, "principal %q is not allowed", email)
+			return
+		}
+
+		s.Log.Info(ctx, "Admin request %s %s by %q", r.Method, r.URL.Path, email)
+		next.ServeHTTP(w, r)
+	})
+}
+
+type adminJob struct {
+	*jobs.Job
+	LogsURLs []string `json:"logsURLs,omitempty"`
+}
+
+type adminJobSummary struct {
+	ID            string      `json:"id"`
+	Trigger       string      `json:"trigger"`
+	Repo          string      `json:"repo"`
+	Status        jobs.Status `json:"status"`
+	Attempts      int         `json:"attempts"`
+	LatestBuildID string      `json:"latestBuildID,omitempty"`
+	CreatedAt     time.Time   `json:"createdAt"`
+	UpdatedAt     time.Time   `json:"updatedAt"`
+}
+
+func (s *Service) adminListJobsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	f := jobs.Filter{
+		Repo:   q.Get("repo"),
+		Status: jobs.Status(strings.ToUpper(q.Get("status"))),
+		Limit:  defaultAdminJobLimit,
+	}
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > maxAdminJobLimit {
+			s.clientError(w, r, http.StatusBadRequest, "limit must be between 1 and %d", maxAdminJobLimit)
+			return
+		}
+		f.Limit = n
+	}
+
+	list, err := s.Jobs.List(ctx, f)
+	if err != nil {
+		s.serverError(w, r, http.StatusInternalServerError, "listing jobs: %v", err)
+		return
+	}
+
+	summaries := make([]adminJobSummary, 0, len(list))
+	for _, j := range s.refreshJobs(ctx, list) {
+		summaries = append(summaries, adminJobSummary{
+			ID:            j.ID,
+			Trigger:       j.Trigger,
+			Repo:          j.FullName(),
+			Status:        j.Status,
+			Attempts:      j.Attempts,
+			LatestBuildID: j.LatestBuildID(),
+			CreatedAt:     j.CreatedAt,
+			UpdatedAt:     j.UpdatedAt,
+		})
+	}
+	s.writeJSON(w, r, summaries)
+}
+
+func (s *Service) adminGetJobHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	job, err := s.Jobs.Get(ctx, r.PathValue("id"))
+	if err != nil {
+		s.jobError(w, r, err)
+		return
+	}
+	if refreshed, err := s.refreshJob(ctx, job); err != nil {
+		s.Log.Warn(ctx, "Failed to refresh job %s, continuing: %v", job.ID, err)
+	} else {
+		job = refreshed
+	}
+	s.writeJSON(w, r, s.adminJob(job))
+}
+
+func (s *Service) adminRetryJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := s.retryJob(r.Context(), r.PathValue("id"))
+	if err != nil {
+		s.jobError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, s.adminJob(job))
+}
+
+func (s *Service) adminCancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := s.cancelJob(r.Context(), r.PathValue("id"))
+	if err != nil {
+		s.jobError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, s.adminJob(job))
+}
+
+type adminPrompts struct {
+	Embedded []string       `json:"embedded"`
+	Sources  []promptLayer  `json:"sources"` // The cached overrides.
+	Renders  []promptRender `json:"renders"` // Most recent first.
+}
+
+func (s *Service) adminPromptsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := adminPrompts{Sources: []promptLayer{}}
+	for _, t := range s.prompts.Templates() {
+		resp.Embedded = append(resp.Embedded, t.Name())
+	}
+	slices.Sort(resp.Embedded)
+
+	s.promptSources.mu.Lock()
+	if l := s.promptSources.gcs; l != nil {
+		resp.Sources = append(resp.Sources, *l)
+	}
+	for _, key := range slices.Sorted(maps.Keys(s.promptSources.repos)) {
+		resp.Sources = append(resp.Sources, *s.promptSources.repos[key])
+	}
+	resp.Renders = slices.Clone(s.promptSources.renders)
+	s.promptSources.mu.Unlock()
+
+	slices.Reverse(resp.Renders)
+	s.writeJSON(w, r, resp)
+}
+
+// adminReloadPromptsHandler drops the cached template overrides, e.g. after
+// updating the GCS prefix.
+func (s *Service) adminReloadPromptsHandler(w http.ResponseWriter, r *http.Request) {
+	s.reloadPrompts()
+	s.Log.Info(r.Context(), "Prompt template overrides will be reloaded.")
+	s.writeJSON(w, r, map[string]bool{"reloaded": true})
+}
+
+func (s *Service) adminJob(job *jobs.Job) adminJob {
+	aj := adminJob{Job: job}
+	for _, id := range job.BuildIDs {
+		aj.LogsURLs = append(aj.LogsURLs, runner.LogsURL(s.ProjectID, s.Region, id))
+	}
+	return aj
+}
+
+func (s *Service) jobError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		s.clientError(w, r, http.StatusNotFound, "%v", err)
+	case errors.Is(err, errJobState):
+		s.clientError(w, r, http.StatusConflict, "%v", err)
+	default:
+		s.serverError(w, r, http.StatusInternalServerError, "%v", err)
+	}
+}
+
+func (s *Service) writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		s.serverError(w, r, http.StatusInternalServerError, "marshalling response: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
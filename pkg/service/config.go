@@ -1,9 +1,13 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/squee1945/pillar-service/pkg/jobs"
 	"github.com/squee1945/pillar-service/pkg/logger"
 	"github.com/squee1945/pillar-service/pkg/secrets"
 )
@@ -32,58 +36,116 @@ type Config struct {
 	SubBuildGoRepository     string
 
 	// Optional
+	// SubBuildMavenRepository, SubBuildNpmRepository and
+	// SubBuildPythonRepository are the Artifact Registry repositories that
+	// sub-builds can upload those ecosystems' artifacts to. Sub-builds cannot
+	// upload artifacts of an ecosystem without one.
+	SubBuildMavenRepository  string
+	SubBuildNpmRepository    string
+	SubBuildPythonRepository string
+
 	Transport   http.RoundTripper
 	ServiceName string
+	Jobs        jobs.Store // Defaults to an in-memory store.
+	// SkipOnboarding disables the pull request proposing a default
+	// .pillar.yaml that is opened when the app is installed on a repository.
+	SkipOnboarding bool
+	// ReleaseBatchWindow is how long to wait for further releases before
+	// upgrading a dependent, so that releases close together are applied in
+	// one pull request. Zero upgrades without waiting.
+	ReleaseBatchWindow time.Duration
+	// PromptTemplatesURL is a gs://bucket/prefix whose *.tmpl objects override
+	// the embedded prompt templates of the same name. If EnableRepoPrompts is
+	// set, templates in a target repository's .pillar/prompts directory
+	// override both. Overrides are reloaded after PromptReloadInterval; zero
+	// reloads them for every render.
+	PromptTemplatesURL   string
+	EnableRepoPrompts    bool
+	PromptReloadInterval time.Duration
+	// SubBuildPolicyURL is the gs:// URL of a JSON policy restricting the
+	// sub-builds that the agent can create. If empty, devhelpermcp applies its
+	// built-in policy.
+	SubBuildPolicyURL string
+	// PromptBudget is the size in bytes that rendered prompts should fit in.
+	// Larger prompts have their payloads trimmed and are logged. Zero means no
+	// budget.
+	PromptBudget int
+
+	// Admin API authentication. Requests must carry an IAP assertion or OIDC
+	// bearer token for AdminAudience; if AdminPrincipals is non-empty, the
+	// token's email must be listed. AdminAuthBypass disables verification and
+	// is intended for local development only.
+	AdminAudience   string
+	AdminPrincipals []string
+	AdminAuthBypass bool
 }
 
-func (c Config) validate() error {
+// Validate reports the settings that are missing or invalid. Secrets is
+// checked by New, so that a config can be validated before its dependencies
+// are created.
+func (c Config) Validate() error {
+	var errs []error
 	if c.ProjectID == "" {
-		return fmt.Errorf("ProjectID must be set")
+		errs = append(errs, errors.New("ProjectID must be set"))
 	}
 	if c.Region == "" {
-		return fmt.Errorf("Region must be set")
+		errs = append(errs, errors.New("Region must be set"))
 	}
 	if c.AppID == 0 {
-		return fmt.Errorf("AppID must be set")
+		errs = append(errs, errors.New("AppID must be set"))
 	}
 	if c.PromptBucket == "" {
-		return fmt.Errorf("PromptBucket must be set")
+		errs = append(errs, errors.New("PromptBucket must be set"))
 	}
 	if c.KMSKeyName == "" {
-		return fmt.Errorf("KMSKeyName must be set")
+		errs = append(errs, errors.New("KMSKeyName must be set"))
 	}
 	if c.RunnerServiceAccount == "" {
-		return fmt.Errorf("RunnerServiceAccount must be set")
+		errs = append(errs, errors.New("RunnerServiceAccount must be set"))
 	}
 	if c.PrepImage == "" {
-		return fmt.Errorf("PrepImage must be set")
+		errs = append(errs, errors.New("PrepImage must be set"))
 	}
 	if c.PromptImage == "" {
-		return fmt.Errorf("PromptImage must be set")
-	}
-	if c.Secrets == nil {
-		return fmt.Errorf("Secrets must be set")
+		errs = append(errs, errors.New("PromptImage must be set"))
 	}
 	if c.WebhookSecretName == "" {
-		return fmt.Errorf("WebhookSecretName must be set")
+		errs = append(errs, errors.New("WebhookSecretName must be set"))
 	}
 	if c.AppPrivateKeySecretName == "" {
-		return fmt.Errorf("AppPrivateKeySecretName must be set")
+		errs = append(errs, errors.New("AppPrivateKeySecretName must be set"))
 	}
 	if c.GeminiAPIKeySecretName == "" {
-		return fmt.Errorf("GeminiAPIKeySecretName must be set")
+		errs = append(errs, errors.New("GeminiAPIKeySecretName must be set"))
 	}
 	if c.SubBuildServiceAccount == "" {
-		return fmt.Errorf("SubBuildServiceAccount must be set")
+		errs = append(errs, errors.New("SubBuildServiceAccount must be set"))
 	}
 	if c.SubBuildLogsBucket == "" {
-		return fmt.Errorf("SubBuildLogsBucket must be set")
+		errs = append(errs, errors.New("SubBuildLogsBucket must be set"))
 	}
 	if c.SubBuildTestOutputBucket == "" {
-		return fmt.Errorf("SubBuildTestOutputBucket must be set")
+		errs = append(errs, errors.New("SubBuildTestOutputBucket must be set"))
 	}
 	if c.SubBuildGoRepository == "" {
-		return fmt.Errorf("SubBuildGoRepository must be set")
+		errs = append(errs, errors.New("SubBuildGoRepository must be set"))
+	}
+	if c.ReleaseBatchWindow < 0 {
+		errs = append(errs, errors.New("ReleaseBatchWindow must be non-negative"))
+	}
+	if c.SubBuildPolicyURL != "" && !strings.HasPrefix(c.SubBuildPolicyURL, "gs://") {
+		errs = append(errs, errors.New("SubBuildPolicyURL must be a gs:// URL"))
+	}
+	if c.PromptTemplatesURL != "" {
+		if _, err := parseGCSPromptSource(c.PromptTemplatesURL); err != nil {
+			errs = append(errs, fmt.Errorf("PromptTemplatesURL: %v", err))
+		}
+	}
+	if c.PromptReloadInterval < 0 {
+		errs = append(errs, errors.New("PromptReloadInterval must be non-negative"))
+	}
+	if c.PromptBudget < 0 {
+		errs = append(errs, errors.New("PromptBudget must be non-negative"))
 	}
-	return nil
+	return errors.Join(errs...)
 }
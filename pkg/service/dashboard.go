@@ -0,0 +1,172 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/squee1945/pillar-service/pkg/jobs"
+	"github.com/squee1945/pillar-service/pkg/runner"
+)
+
+const (
+	dashboardDeliveries = 50
+	dashboardJobs       = 100
+)
+
+//go:embed dashboard/*.html
+var dashboardFS embed.FS
+
+func parseDashboardTemplates(_ context.Context, projectID, region string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"logsURL": func(buildID string) string {
+			return runner.LogsURL(projectID, region, buildID)
+		},
+		"timestamp": func(t time.Time) string {
+			return t.UTC().Format(time.DateTime)
+		},
+	}
+	return template.New("dashboard").Funcs(funcs).ParseFS(dashboardFS, "dashboard/*.html")
+}
+
+type dashboardData struct {
+	ServiceName   string
+	Generated     time.Time
+	Deliveries    []*jobs.Delivery
+	ActiveJobs    []*jobs.Job
+	FinishedJobs  []*jobs.Job
+	Repos         []*dashboardRepo
+	Installations []*jobs.Installation
+}
+
+type dashboardRepo struct {
+	FullName     string
+	Installed    bool
+	Jobs         int
+	Deliveries   int
+	LastActivity time.Time
+	Config       []dashboardSetting
+}
+
+type dashboardSetting struct {
+	Name  string
+	Value string
+}
+
+func (s *Service) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	data, err := s.dashboardData(ctx)
+	if err != nil {
+		s.serverError(w, r, http.StatusInternalServerError, "loading dashboard: %v", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := s.dashboard.ExecuteTemplate(&buf, "index.html", data); err != nil {
+		s.serverError(w, r, http.StatusInternalServerError, "rendering dashboard: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (s *Service) dashboardData(ctx context.Context) (*dashboardData, error) {
+	deliveries, err := s.Jobs.ListDeliveries(ctx, dashboardDeliveries)
+	if err != nil {
+		return nil, fmt.Errorf("listing deliveries: %w", err)
+	}
+	list, err := s.Jobs.List(ctx, jobs.Filter{Limit: dashboardJobs})
+	if err != nil {
+		return nil, fmt.Errorf("listing jobs: %w", err)
+	}
+	installations, err := s.Jobs.ListInstallations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing installations: %w", err)
+	}
+
+	data := &dashboardData{
+		ServiceName:   s.ServiceName,
+		Generated:     time.Now(),
+		Deliveries:    deliveries,
+		Installations: installations,
+	}
+
+	repos := make(map[string]*dashboardRepo)
+	repo := func(name string, at time.Time) *dashboardRepo {
+		rp, ok := repos[name]
+		if !ok {
+			rp = &dashboardRepo{FullName: name, Config: s.repoSettings(ctx, name)}
+			repos[name] = rp
+		}
+		if at.After(rp.LastActivity) {
+			rp.LastActivity = at
+		}
+		return rp
+	}
+
+	for _, j := range s.refreshJobs(ctx, list) {
+		if j.Status.Terminal() {
+			data.FinishedJobs = append(data.FinishedJobs, j)
+		} else {
+			data.ActiveJobs = append(data.ActiveJobs, j)
+		}
+		repo(j.FullName(), j.UpdatedAt).Jobs++
+	}
+	for _, d := range deliveries {
+		if d.Repo != "" {
+			repo(d.Repo, d.ReceivedAt).Deliveries++
+		}
+	}
+	for _, inst := range installations {
+		for _, r := range inst.Repos {
+			repo(r.FullName, time.Time{}).Installed = true
+		}
+	}
+
+	for _, rp := range repos {
+		data.Repos = append(data.Repos, rp)
+	}
+	slices.SortFunc(data.Repos, func(a, b *dashboardRepo) int {
+		return b.LastActivity.Compare(a.LastActivity)
+	})
+	return data, nil
+}
+
+// repoSettings describes the configuration that applies to a repository.
+// Repository config is only shown if it has been loaded recently.
+func (s *Service) repoSettings(_ context.Context, fullName string) []dashboardSetting {
+	settings := []dashboardSetting{
+		{Name: "Service commands", Value: fmt.Sprintf("/%[1]s %[2]s (pull requests), /%[1]s %[3]s (issues)", s.ServiceName, cmdPopulatePR, cmdImplement)},
+		{Name: "Implement label", Value: s.implementLabel()},
+	}
+	if c, ok := s.cachedRepoConfig(fullName); ok {
+		pr := "disabled"
+		if c.PullRequests.Enabled {
+			pr = fmt.Sprintf("on %s, debounce %s, drafts %t", strings.Join(c.PullRequests.Actions, "/"), c.PullRequests.Debounce, c.PullRequests.IncludeDrafts)
+		}
+		settings = append(settings, dashboardSetting{Name: "Automatic " + cmdPopulatePR, Value: pr})
+
+		var triggers []string
+		if len(c.Releases.Actions) > 0 {
+			triggers = append(triggers, "releases "+strings.Join(c.Releases.Actions, "/"))
+		}
+		if c.Releases.Tags {
+			triggers = append(triggers, "tags")
+		}
+		releases := "disabled"
+		if len(triggers) > 0 {
+			releases = fmt.Sprintf("on %s, upgrading %s/%s, prereleases %t, semver only %t",
+				strings.Join(triggers, " and "), dependent.owner, dependent.repo, c.Releases.IncludePrereleases, c.Releases.SemverOnly)
+		}
+		settings = append(settings, dashboardSetting{Name: "Release upgrades", Value: releases})
+	}
+	return settings
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// debouncer delays work per key, so that a burst of events for the same key
+// results in a single call of the most recently scheduled function.
+//
+// Scheduled work runs in the background after the webhook request has
+// returned, so the Cloud Run service must have CPU allocated outside of
+// requests.
+type debouncer struct {
+	mu      sync.Mutex
+	pending map[string]*debounced
+}
+
+// debounced is the work pending for a key.
+type debounced struct {
+	timer *time.Timer
+	fn    func()
+}
+
+func newDebouncer() *debouncer {
+	return &debouncer{pending: make(map[string]*debounced)}
+}
+
+// schedule runs fn after wait, replacing any work pending for key. It reports
+// whether earlier pending work was superseded.
+func (d *debouncer) schedule(key string, wait time.Duration, fn func()) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	superseded := false
+	if p, ok := d.pending[key]; ok {
+		superseded = p.timer.Stop()
+	}
+
+	p := &debounced{fn: fn}
+	p.timer = time.AfterFunc(wait, func() {
+		d.mu.Lock()
+		if d.pending[key] == p {
+			delete(d.pending, key)
+		}
+		d.mu.Unlock()
+		fn()
+	})
+	d.pending[key] = p
+	return superseded
+}
+
+// cancelPrefix cancels pending work for keys with the given prefix, returning
+// how many were cancelled.
+func (d *debouncer) cancelPrefix(prefix string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.takePrefix(prefix))
+}
+
+// flushPrefix takes the pending work for keys with the given prefix, without
+// waiting for it to be due, and returns it for the caller to run.
+func (d *debouncer) flushPrefix(prefix string) []func() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.takePrefix(prefix)
+}
+
+// takePrefix stops the pending work for keys with the given prefix and
+// returns the functions that had not started. d.mu must be held.
+func (d *debouncer) takePrefix(prefix string) []func() {
+	var fns []func()
+	for key, p := range d.pending {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if p.timer.Stop() {
+			fns = append(fns, p.fn)
+		}
+		delete(d.pending, key)
+	}
+	return fns
+}
+
+// count returns the number of keys with scheduled work.
+func (d *debouncer) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pending)
+}
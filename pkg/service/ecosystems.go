@@ -0,0 +1,165 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v75/github"
+	"golang.org/x/mod/modfile"
+)
+
+// ecosystem is a package ecosystem, named as in the OSV schema.
+type ecosystem string
+
+const (
+	ecosystemGo    ecosystem = "Go"
+	ecosystemNPM   ecosystem = "npm"
+	ecosystemPyPI  ecosystem = "PyPI"
+	ecosystemMaven ecosystem = "Maven"
+	ecosystemCargo ecosystem = "crates.io"
+)
+
+// templateName is the prompt template with upgrade instructions for the
+// ecosystem.
+func (e ecosystem) templateName() string {
+	switch e {
+	case ecosystemCargo:
+		return "ecosystem_cargo"
+	default:
+		return "ecosystem_" + strings.ToLower(string(e))
+	}
+}
+
+// manifest describes how to recognise an ecosystem from a file, and how to
+// read the package name from it.
+type manifest struct {
+	file      string
+	ecosystem ecosystem
+	name      func([]byte) string
+}
+
+// manifests are checked in order; when a directory has several, the first
+// determines the package that a release publishes.
+var manifests = []manifest{
+	{"go.mod", ecosystemGo, func(b []byte) string { return modfile.ModulePath(b) }},
+	{"Cargo.toml", ecosystemCargo, func(b []byte) string { return tomlString(b, "package", "name") }},
+	{"package.json", ecosystemNPM, packageJSONName},
+	{"pyproject.toml", ecosystemPyPI, func(b []byte) string {
+		if name := tomlString(b, "project", "name"); name != "" {
+			return name
+		}
+		return tomlString(b, "tool.poetry", "name")
+	}},
+	{"setup.py", ecosystemPyPI, nil},
+	{"setup.cfg", ecosystemPyPI, nil},
+	{"requirements.txt", ecosystemPyPI, nil},
+	{"pom.xml", ecosystemMaven, pomName},
+	{"build.gradle", ecosystemMaven, nil},
+	{"build.gradle.kts", ecosystemMaven, nil},
+}
+
+// ecosystemInfo is an ecosystem detected in a repository directory.
+type ecosystemInfo struct {
+	Ecosystem ecosystem
+	Manifest  string // Path of the manifest file, e.g., "go.mod".
+	Name      string // The package name, if the manifest declares one.
+}
+
+// detectEcosystems lists the ecosystems of the manifests in dir at ref (the
+// default branch if empty), in the order of manifests.
+func (s *Service) detectEcosystems(ctx context.Context, ghClient *github.Client, owner, repo, ref, dir string) ([]ecosystemInfo, error) {
+	opts := &github.RepositoryContentGetOptions{Ref: ref}
+	_, entries, _, err := ghClient.Repositories.GetContents(ctx, owner, repo, dir, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s/%s:%s: %w", owner, repo, dir, err)
+	}
+	files := make(map[string]bool)
+	for _, e := range entries {
+		if e.GetType() == "file" {
+			files[e.GetName()] = true
+		}
+	}
+
+	var out []ecosystemInfo
+	for _, m := range manifests {
+		if !files[m.file] || slices.ContainsFunc(out, func(e ecosystemInfo) bool { return e.Ecosystem == m.ecosystem }) {
+			continue
+		}
+		info := ecosystemInfo{Ecosystem: m.ecosystem, Manifest: path.Join(dir, m.file)}
+		if m.name != nil {
+			file, _, resp, err := ghClient.Repositories.GetContents(ctx, owner, repo, info.Manifest, opts)
+			switch {
+			case statusCode(resp) == http.StatusNotFound:
+				continue
+			case err != nil:
+				return nil, fmt.Errorf("getting %s: %w", info.Manifest, err)
+			}
+			content, err := file.GetContent()
+			if err != nil {
+				return nil, fmt.Errorf("decoding %s: %w", info.Manifest, err)
+			}
+			info.Name = m.name([]byte(content))
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+func packageJSONName(b []byte) string {
+	var pkg struct {
+		Name string `json:"name"`
+	}
+	_ = json.Unmarshal(b, &pkg)
+	return pkg.Name
+}
+
+// pomName returns "<groupId>:<artifactId>", taking the groupId from the parent
+// if the project does not declare one.
+func pomName(b []byte) string {
+	var pom struct {
+		GroupID    string `xml:"groupId"`
+		ArtifactID string `xml:"artifactId"`
+		Parent     struct {
+			GroupID string `xml:"groupId"`
+		} `xml:"parent"`
+	}
+	if err := xml.Unmarshal(b, &pom); err != nil || pom.ArtifactID == "" {
+		return ""
+	}
+	return cmp.Or(pom.GroupID, pom.Parent.GroupID) + ":" + pom.ArtifactID
+}
+
+// tomlString returns a string value from a TOML table. It only understands
+// `key = "value"` lines, which is enough for package names.
+func tomlString(b []byte, table, key string) string {
+	current := ""
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			current = strings.TrimSpace(strings.Trim(line, "[]"))
+			continue
+		}
+		if current != table {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(k) != key {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			return v[1 : len(v)-1]
+		}
+	}
+	return ""
+}
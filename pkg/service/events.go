@@ -2,7 +2,11 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"path"
+	"slices"
 	"strings"
 
 	"github.com/google/go-github/v75/github"
@@ -10,10 +14,19 @@ import (
 
 const (
 	cmdPopulatePR = "populate-pr"
+	cmdImplement  = "implement"
+	cmdFix        = "fix"
+
+	// pullRequestDebounceKeyPrefix starts the debouncer keys of automatic
+	// populate-pr runs.
+	pullRequestDebounceKeyPrefix = "pull_request/"
 )
 
 var (
 	dependent = repository{owner: "kmonty-catamaran", repo: "deps-weather-webapp"}
+
+	// trustedAssociations are the author associations that can run commands.
+	trustedAssociations = []string{"OWNER", "MEMBER", "COLLABORATOR"}
 )
 
 type repository struct {
@@ -21,29 +34,127 @@ type repository struct {
 	repo  string
 }
 
-func (s *Service) releaseEventHandler(ctx context.Context, event *github.ReleaseEvent) error {
-	switch action := event.GetAction(); action {
-	case "published":
+// releaseChanges is the "changes" object of an edited release event, which
+// go-github does not decode.
+type releaseChanges struct {
+	Changes map[string]json.RawMessage `json:"changes"`
+}
+
+// releaseEventHandler handles a release event; payload is its raw JSON.
+func (s *Service) releaseEventHandler(ctx context.Context, event *github.ReleaseEvent, payload []byte) error {
+	action := event.GetAction()
+	switch action {
+	case "published", "prereleased", "released", "edited":
 		break
 	default:
 		s.Log.Debug(ctx, "Ignoring release %s event", action)
 		return nil
 	}
 
-	// Find dependents (reverse dependencies).
-	// TODO
+	installationID := event.GetInstallation().GetID()
+	owner, repo := event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName()
+	release := event.GetRelease()
+	if release.GetDraft() {
+		s.Log.Debug(ctx, "Ignoring release %s event (repo %s/%s); %s is a draft.", action, owner, repo, release.GetTagName())
+		return nil
+	}
+	if action == "edited" {
+		// Only edits to the version matter; not to the notes or title.
+		var rc releaseChanges
+		if err := json.Unmarshal(payload, &rc); err != nil {
+			return fmt.Errorf("parsing release changes: %v", err)
+		}
+		if rc.Changes["tag_name"] == nil && rc.Changes["prerelease"] == nil {
+			s.Log.Debug(ctx, "Ignoring release edited event (repo %s/%s); %s kept its tag and pre-release flag.", owner, repo, release.GetTagName())
+			return nil
+		}
+	}
 
-	fork, err := s.fork(ctx, event.GetInstallation().GetID(), dependent.owner, dependent.repo, event.GetRepo().GetOwner())
+	cfg, err := s.repoConfig(ctx, installationID, owner, repo)
 	if err != nil {
-		return fmt.Errorf("forking %s/%s: %v", dependent.owner, dependent.repo, err)
+		return fmt.Errorf("loading repo config: %v", err)
+	}
+	if !slices.Contains(cfg.Releases.Actions, action) {
+		s.Log.Debug(ctx, "Ignoring release %s event (repo %s/%s); not enabled in %s.", action, owner, repo, repoConfigPath)
+		return nil
 	}
 
-	prompt, err := s.renderPrompt(ctx, &promptReleasePublished{event: event, dependent: fmt.Sprintf("https://github.com/%s/%s", dependent.owner, dependent.repo)})
+	trigger := jobTrigger{name: "release/" + action, url: release.GetHTMLURL()}
+	return s.releaseDependents(ctx, trigger, installationID, event.GetRepo(), release.GetTagName(), release.GetPrerelease(), cfg.Releases, event)
+}
+
+func (s *Service) createEventHandler(ctx context.Context, event *github.CreateEvent) error {
+	installationID := event.GetInstallation().GetID()
+	owner, repo := event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName()
+	if event.GetRefType() != "tag" {
+		s.Log.Debug(ctx, "Ignoring create %s event (repo %s/%s)", event.GetRefType(), owner, repo)
+		return nil
+	}
+
+	cfg, err := s.repoConfig(ctx, installationID, owner, repo)
 	if err != nil {
-		return fmt.Errorf("rendering prompt: %v", err)
+		return fmt.Errorf("loading repo config: %v", err)
+	}
+	if !cfg.Releases.Tags {
+		s.Log.Debug(ctx, "Ignoring tag %s (repo %s/%s); tag releases are not enabled in %s.", event.GetRef(), owner, repo, repoConfigPath)
+		return nil
 	}
 
-	return s.run(ctx, event.GetInstallation().GetID(), fork, prompt)
+	trigger := jobTrigger{name: triggerCreateTag, url: event.GetRepo().GetHTMLURL() + "/tree/" + event.GetRef()}
+	return s.releaseDependents(ctx, trigger, installationID, event.GetRepo(), event.GetRef(), false, cfg.Releases, event)
+}
+
+// releaseDependents upgrades the dependents of upstream to the version in tag.
+func (s *Service) releaseDependents(ctx context.Context, trigger jobTrigger, installationID int64, upstream *github.Repository, tag string, prerelease bool, cfg releasesConfig, event any) error {
+	owner, repo := upstream.GetOwner().GetLogin(), upstream.GetName()
+
+	newVersion, ok := parseReleaseVersion(tag)
+	if !ok {
+		if cfg.SemverOnly {
+			s.Log.Info(ctx, "Ignoring release %s (repo %s/%s); not a semantic version.", tag, owner, repo)
+			return nil
+		}
+		// Upgrade to the tag as is, without a previous version.
+		newVersion = rawReleaseVersion(tag)
+	}
+	if (prerelease || newVersion.prerelease()) && !cfg.IncludePrereleases {
+		s.Log.Info(ctx, "Ignoring release %s (repo %s/%s); pre-releases are not enabled in %s.", tag, owner, repo, repoConfigPath)
+		return nil
+	}
+
+	var oldVersion releaseVersion
+	if ok {
+		var err error
+		if oldVersion, _, err = s.previousVersion(ctx, installationID, owner, repo, newVersion, cfg.IncludePrereleases); err != nil {
+			s.Log.Warn(ctx, "Failed to find the version before %s (repo %s/%s), continuing: %v", tag, owner, repo, err)
+		}
+	}
+
+	// The released package is the one in the tag's module directory.
+	var pkg ecosystemInfo
+	if ghClient, err := s.githubClient(ctx, installationID); err != nil {
+		s.Log.Warn(ctx, "Failed to create github client, continuing: %v", err)
+	} else if ecosystems, err := s.detectEcosystems(ctx, ghClient, owner, repo, newVersion.tag, strings.TrimPrefix(path.Dir(newVersion.tag), ".")); err != nil {
+		s.Log.Warn(ctx, "Failed to detect the ecosystem of %s/%s at %s, continuing: %v", owner, repo, tag, err)
+	} else if len(ecosystems) > 0 {
+		pkg = ecosystems[0]
+	}
+
+	// Find dependents (reverse dependencies).
+	// TODO
+
+	s.queueUpgrade(ctx, trigger, installationID, upstream.GetOwner(), dependent, releaseUpgrade{
+		Package:    upstream.GetHTMLURL(),
+		Ecosystem:  pkg.Ecosystem,
+		Name:       pkg.Name,
+		Tag:        newVersion.tag,
+		NewVersion: newVersion.version,
+		OldVersion: oldVersion.version,
+		Prerelease: newVersion.prerelease(),
+		ReleaseURL: trigger.url,
+		semver:     newVersion.semver,
+	}, event)
+	return nil
 }
 
 func (s *Service) issueCommentHandler(ctx context.Context, event *github.IssueCommentEvent) error {
@@ -59,22 +170,28 @@ func (s *Service) issueCommentHandler(ctx context.Context, event *github.IssueCo
 	owner, repo := event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName()
 	issueID, commentID := event.GetIssue().GetID(), event.GetComment().GetID()
 
-	if !event.GetIssue().IsPullRequest() {
-		s.Log.Debug(ctx, "Ignoring issue_comment %d (issue %d, repo %s/%s) created event for non-pull-request.", commentID, issueID, owner, repo)
-		return nil
-	}
-
 	command, forService := s.extractServiceCommand(ctx, event.GetComment().GetBody())
 	if !forService {
 		s.Log.Debug(ctx, "Ignoring comment %d (issue %d, repo %s/%s); no service command found.", commentID, issueID, owner, repo)
 		return nil
 	}
 
-	switch command {
-	case cmdPopulatePR:
-		break
+	isPR := event.GetIssue().IsPullRequest()
+	switch {
+	case command == cmdPopulatePR && isPR:
+	case command == cmdImplement && !isPR:
 	default:
-		s.Log.Debug(ctx, "Ignoring comment %d (issue %d, repo %s/%s); unknown service command %q.", commentID, issueID, owner, repo, command)
+		s.Log.Debug(ctx, "Ignoring comment %d (issue %d, repo %s/%s); unknown service command %q (pull request: %t).", commentID, issueID, owner, repo, command, isPR)
+		return nil
+	}
+
+	author := event.GetComment().GetUser().GetLogin()
+	allowed, err := s.commandAllowed(ctx, installationID, owner, repo, author, event.GetComment().GetAuthorAssociation())
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		s.Log.Info(ctx, "Ignoring comment %d (issue %d, repo %s/%s); %s (%s) is not allowed to run commands.", commentID, issueID, owner, repo, author, event.GetComment().GetAuthorAssociation())
 		return nil
 	}
 
@@ -88,44 +205,453 @@ func (s *Service) issueCommentHandler(ctx context.Context, event *github.IssueCo
 		s.Log.Warn(ctx, "Failed to add 'eyes' reaction to comment %d (issue %d, repo %s/%s), continuing: %v", commentID, issueID, owner, repo, err)
 	}
 
+	if command == cmdImplement {
+		trigger := jobTrigger{name: triggerImplementComment, url: event.GetComment().GetHTMLURL()}
+		return s.implementIssue(ctx, trigger, installationID, event.GetRepo(), event.GetIssue())
+	}
+
 	// Fetch the PR head commit.
 	issueNum := event.GetIssue().GetNumber()
 	pr, _, err := ghClient.PullRequests.Get(ctx, owner, repo, issueNum)
 	if err != nil {
 		return fmt.Errorf("getting pull request %d: %w", issueNum, err)
 	}
-	commit := pr.GetHead().GetSHA()
 
-	// Run the prompt.
-	t := &promptIssueCommentCreatedPopulatePR{
+	trigger := jobTrigger{name: triggerPopulatePR, url: event.GetIssue().GetHTMLURL()}
+	return s.populatePR(ctx, trigger, installationID, event.GetRepo(), pr)
+}
+
+// populatePR runs the populate-pr prompt against the head commit of pr.
+func (s *Service) populatePR(ctx context.Context, trigger jobTrigger, installationID int64, repo *github.Repository, pr *github.PullRequest) error {
+	t := &promptPopulatePR{
 		projectID:        s.ProjectID,
 		region:           s.Region,
-		commit:           commit,
+		commit:           pr.GetHead().GetSHA(),
 		testOutputBucket: s.SubBuildTestOutputBucket,
 		goRepository:     s.SubBuildGoRepository,
-		event:            event,
+		pullRequest:      pr,
 	}
 
-	prompt, err := s.renderPrompt(ctx, t)
+	prompt, err := s.renderPrompt(ctx, installationID, repo.GetOwner().GetLogin(), repo.GetName(), t)
 	if err != nil {
 		return fmt.Errorf("rendering prompt: %v", err)
 	}
 	devHelperIncludeTools := []string{
 		"create_cloud_build",
 		"get_cloud_build",
+		"wait_for_cloud_build",
+		"cancel_cloud_build",
+		"list_cloud_builds",
 		"get_cloud_build_logs",
 		"fetch_test_output",
+		"get_test_results",
 		"fetch_provenance",
 	}
-	if err := s.run(ctx, installationID, event.GetRepo(), prompt, withDevHelperIncludeTools(devHelperIncludeTools)); err != nil {
+	return s.run(ctx, trigger, installationID, repo, prompt, withDevHelperIncludeTools(devHelperIncludeTools))
+}
+
+func (s *Service) issuesEventHandler(ctx context.Context, event *github.IssuesEvent) error {
+	switch action := event.GetAction(); action {
+	case "labeled":
+		break
+	default:
+		s.Log.Debug(ctx, "Ignoring issues %s event", action)
+		return nil
+	}
+
+	owner, repo := event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName()
+	issue := event.GetIssue()
+	if label := event.GetLabel().GetName(); label != s.implementLabel() {
+		s.Log.Debug(ctx, "Ignoring issues labeled event (issue %d, repo %s/%s); label %q is not %q.", issue.GetNumber(), owner, repo, label, s.implementLabel())
+		return nil
+	}
+	if issue.IsPullRequest() || issue.GetState() != "open" {
+		s.Log.Debug(ctx, "Ignoring issues labeled event (issue %d, repo %s/%s); not an open issue.", issue.GetNumber(), owner, repo)
+		return nil
+	}
+
+	trigger := jobTrigger{name: triggerImplementLabel, url: issue.GetHTMLURL()}
+	return s.implementIssue(ctx, trigger, event.GetInstallation().GetID(), event.GetRepo(), issue)
+}
+
+// implementLabel is the issue label that asks the service to implement an
+// issue, e.g., "pillar:implement".
+func (s *Service) implementLabel() string {
+	return s.ServiceName + ":" + cmdImplement
+}
+
+// implementIssue runs an agent that implements issue against the repository's
+// default branch and opens a draft pull request referencing it. Progress is
+// reported with comments on the issue.
+func (s *Service) implementIssue(ctx context.Context, trigger jobTrigger, installationID int64, repo *github.Repository, issue *github.Issue) error {
+	owner, name, number := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+
+	ghClient, err := s.githubClient(ctx, installationID)
+	if err != nil {
+		return fmt.Errorf("creating github client: %v", err)
+	}
+	comment := func(body string) {
+		if _, _, err := ghClient.Issues.CreateComment(ctx, owner, name, number, &github.IssueComment{Body: github.Ptr(body)}); err != nil {
+			s.Log.Warn(ctx, "Failed to comment on issue %d (repo %s/%s), continuing: %v", number, owner, name, err)
+		}
+	}
+
+	prompt, err := s.renderPrompt(ctx, installationID, owner, name, &promptImplementIssue{serviceName: s.ServiceName, repo: repo, issue: issue})
+	if err != nil {
+		return fmt.Errorf("rendering prompt: %v", err)
+	}
+
+	s.Log.Info(ctx, "Implementing issue %d (repo %s/%s)", number, owner, name)
+	if err := s.run(ctx, trigger, installationID, repo, prompt); err != nil {
+		comment(fmt.Sprintf("%s could not start working on this issue: %v", s.ServiceName, err))
 		return err
 	}
+	comment(fmt.Sprintf("%s has started working on this issue against `%s`. Progress will be posted here, and a draft pull request will reference this issue.", s.ServiceName, repo.GetDefaultBranch()))
+	return nil
+}
+
+func (s *Service) pullRequestReviewCommentHandler(ctx context.Context, event *github.PullRequestReviewCommentEvent) error {
+	switch action := event.GetAction(); action {
+	case "created":
+		break
+	default:
+		s.Log.Debug(ctx, "Ignoring pull_request_review_comment %s event", action)
+		return nil
+	}
 
+	owner, repo := event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName()
+	comment := event.GetComment()
+	if command, _ := s.extractServiceCommand(ctx, comment.GetBody()); command != cmdFix {
+		s.Log.Debug(ctx, "Ignoring review comment %d (PR %d, repo %s/%s); no %q command found.", comment.GetID(), event.GetPullRequest().GetNumber(), owner, repo, cmdFix)
+		return nil
+	}
+	author := comment.GetUser().GetLogin()
+	allowed, err := s.commandAllowed(ctx, event.GetInstallation().GetID(), owner, repo, author, comment.GetAuthorAssociation())
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		s.Log.Info(ctx, "Ignoring review comment %d (PR %d, repo %s/%s); %s (%s) is not allowed to run commands.", comment.GetID(), event.GetPullRequest().GetNumber(), owner, repo, author, comment.GetAuthorAssociation())
+		return nil
+	}
+
+	comments := []*github.PullRequestComment{comment}
+	if parentID := comment.GetInReplyTo(); parentID != 0 {
+		// The feedback is in the comment that starts the thread.
+		ghClient, err := s.githubClient(ctx, event.GetInstallation().GetID())
+		if err != nil {
+			return fmt.Errorf("creating github client: %v", err)
+		}
+		parent, _, err := ghClient.PullRequests.GetComment(ctx, owner, repo, parentID)
+		if err != nil {
+			return fmt.Errorf("getting review comment %d: %w", parentID, err)
+		}
+		comments = []*github.PullRequestComment{parent, comment}
+	}
+
+	trigger := jobTrigger{name: triggerFixReviewComment, url: comment.GetHTMLURL()}
+	return s.fixReview(ctx, trigger, event.GetInstallation().GetID(), event.GetRepo(), event.GetPullRequest(), comment.GetID(), "", comments)
+}
+
+func (s *Service) pullRequestReviewHandler(ctx context.Context, event *github.PullRequestReviewEvent) error {
+	switch action := event.GetAction(); action {
+	case "submitted":
+		break
+	default:
+		s.Log.Debug(ctx, "Ignoring pull_request_review %s event", action)
+		return nil
+	}
+
+	installationID := event.GetInstallation().GetID()
+	owner, repo := event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName()
+	review, pr := event.GetReview(), event.GetPullRequest()
+	if command, _ := s.extractServiceCommand(ctx, review.GetBody()); command != cmdFix {
+		s.Log.Debug(ctx, "Ignoring review %d (PR %d, repo %s/%s); no %q command found.", review.GetID(), pr.GetNumber(), owner, repo, cmdFix)
+		return nil
+	}
+	author := review.GetUser().GetLogin()
+	allowed, err := s.commandAllowed(ctx, installationID, owner, repo, author, review.GetAuthorAssociation())
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		s.Log.Info(ctx, "Ignoring review %d (PR %d, repo %s/%s); %s (%s) is not allowed to run commands.", review.GetID(), pr.GetNumber(), owner, repo, author, review.GetAuthorAssociation())
+		return nil
+	}
+
+	ghClient, err := s.githubClient(ctx, installationID)
+	if err != nil {
+		return fmt.Errorf("creating github client: %v", err)
+	}
+	var comments []*github.PullRequestComment
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := ghClient.PullRequests.ListReviewComments(ctx, owner, repo, pr.GetNumber(), review.GetID(), opts)
+		if err != nil {
+			return fmt.Errorf("listing comments of review %d: %w", review.GetID(), err)
+		}
+		comments = append(comments, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	if len(comments) == 0 {
+		s.Log.Debug(ctx, "Ignoring review %d (PR %d, repo %s/%s); it has no comments to address.", review.GetID(), pr.GetNumber(), owner, repo)
+		return nil
+	}
+
+	trigger := jobTrigger{name: triggerFixReview, url: review.GetHTMLURL()}
+	return s.fixReview(ctx, trigger, installationID, event.GetRepo(), pr, 0, review.GetBody(), comments)
+}
+
+// fixReview runs an agent on the head branch of pr that pushes a commit
+// addressing the review comments and replies in their threads. If commandID is
+// set, it is the review comment that carried the command.
+func (s *Service) fixReview(ctx context.Context, trigger jobTrigger, installationID int64, repo *github.Repository, pr *github.PullRequest, commandID int64, reviewBody string, comments []*github.PullRequestComment) error {
+	owner, name, number := repo.GetOwner().GetLogin(), repo.GetName(), pr.GetNumber()
+
+	ghClient, err := s.githubClient(ctx, installationID)
+	if err != nil {
+		return fmt.Errorf("creating github client: %v", err)
+	}
+	if commandID != 0 {
+		if _, _, err := ghClient.Reactions.CreatePullRequestCommentReaction(ctx, owner, name, commandID, "eyes"); err != nil {
+			s.Log.Warn(ctx, "Failed to add 'eyes' reaction to review comment %d (PR %d, repo %s/%s), continuing: %v", commandID, number, owner, name, err)
+		}
+	}
+
+	// The runner token is restricted to this repository, so it cannot push to
+	// the head branch of a pull request from a fork.
+	if pr.GetHead().GetRepo().GetID() != repo.GetID() {
+		s.Log.Info(ctx, "Not fixing review on PR %d (repo %s/%s); head branch is in fork %s.", number, owner, name, pr.GetHead().GetRepo().GetFullName())
+		if commandID != 0 {
+			body := fmt.Sprintf("%s cannot push to pull requests from forks.", s.ServiceName)
+			if _, _, err := ghClient.PullRequests.CreateCommentInReplyTo(ctx, owner, name, number, body, commandID); err != nil {
+				s.Log.Warn(ctx, "Failed to reply to review comment %d (PR %d, repo %s/%s), continuing: %v", commandID, number, owner, name, err)
+			}
+		}
+		return nil
+	}
+
+	prompt, err := s.renderPrompt(ctx, installationID, owner, name, &promptReviewFix{pullRequest: pr, reviewBody: reviewBody, comments: comments})
+	if err != nil {
+		return fmt.Errorf("rendering prompt: %v", err)
+	}
+
+	s.Log.Info(ctx, "Fixing %d review comment(s) on PR %d (repo %s/%s)", len(comments), number, owner, name)
+	return s.run(ctx, trigger, installationID, repo, prompt, withBranch(pr.GetHead().GetRef()))
+}
+
+func (s *Service) pullRequestEventHandler(ctx context.Context, event *github.PullRequestEvent) error {
+	action := event.GetAction()
+	switch action {
+	case "opened", "synchronize", "ready_for_review", "reopened":
+		break
+	default:
+		s.Log.Debug(ctx, "Ignoring pull_request %s event", action)
+		return nil
+	}
+
+	installationID := event.GetInstallation().GetID()
+	owner, repo := event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName()
+	pr := event.GetPullRequest()
+	number, headSHA := pr.GetNumber(), pr.GetHead().GetSHA()
+
+	cfg, err := s.repoConfig(ctx, installationID, owner, repo)
+	if err != nil {
+		return fmt.Errorf("loading repo config: %v", err)
+	}
+	prCfg := cfg.PullRequests
+	if !prCfg.triggersOn(action) {
+		s.Log.Debug(ctx, "Ignoring pull_request %s event (PR %d, repo %s/%s); not enabled in %s.", action, number, owner, repo, repoConfigPath)
+		return nil
+	}
+	if pr.GetDraft() && !prCfg.IncludeDrafts {
+		s.Log.Debug(ctx, "Ignoring pull_request %s event (PR %d, repo %s/%s); draft pull requests are skipped.", action, number, owner, repo)
+		return nil
+	}
+
+	// The run happens after the webhook has been acknowledged, so it must not
+	// inherit the request's cancellation.
+	runCtx := context.WithoutCancel(ctx)
+	key := fmt.Sprintf("%s%s/%s#%d", pullRequestDebounceKeyPrefix, owner, repo, number)
+	superseded := s.debouncer.schedule(key, prCfg.Debounce, func() {
+		if err := s.autoPopulatePR(runCtx, installationID, event.GetRepo(), number, headSHA); err != nil {
+			s.Log.Error(runCtx, "Automatic populate-pr for %s/%s#%d failed: %v", owner, repo, number, err)
+		}
+	})
+	s.Log.Info(ctx, "Scheduled populate-pr for %s/%s#%d at %s in %s (superseded pending run: %t)", owner, repo, number, shortSHA(headSHA), prCfg.Debounce, superseded)
 	return nil
 }
 
+// autoPopulatePR runs populate-pr once the debounce window has passed, as long
+// as headSHA is still the head of an open pull request.
+func (s *Service) autoPopulatePR(ctx context.Context, installationID int64, repo *github.Repository, number int, headSHA string) error {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+
+	ghClient, err := s.githubClient(ctx, installationID)
+	if err != nil {
+		return fmt.Errorf("creating github client: %v", err)
+	}
+	pr, _, err := ghClient.PullRequests.Get(ctx, owner, name, number)
+	if err != nil {
+		return fmt.Errorf("getting pull request %d: %w", number, err)
+	}
+	if pr.GetState() != "open" {
+		s.Log.Debug(ctx, "Skipping populate-pr for %s/%s#%d; pull request is %s.", owner, name, number, pr.GetState())
+		return nil
+	}
+	if got := pr.GetHead().GetSHA(); got != headSHA {
+		s.Log.Debug(ctx, "Skipping populate-pr for %s/%s#%d at %s; head moved to %s.", owner, name, number, shortSHA(headSHA), shortSHA(got))
+		return nil
+	}
+
+	trigger := jobTrigger{name: triggerAutoPopulatePR, url: pr.GetHTMLURL()}
+	return s.populatePR(ctx, trigger, installationID, repo, pr)
+}
+
+func (s *Service) pushEventHandler(ctx context.Context, event *github.PushEvent) error {
+	installationID := event.GetInstallation().GetID()
+	owner, repo := event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName()
+
+	branch, ok := strings.CutPrefix(event.GetRef(), "refs/heads/")
+	if !ok || event.GetDeleted() {
+		s.Log.Debug(ctx, "Ignoring push to %s (repo %s/%s); not a branch update.", event.GetRef(), owner, repo)
+		return nil
+	}
+
+	changed := pushedFiles(event)
+	if branch == event.GetRepo().GetDefaultBranch() && slices.ContainsFunc(changed, func(f string) bool { return strings.HasPrefix(f, repoPromptsDir+"/") }) {
+		s.Log.Info(ctx, "Reloading prompt templates of %s/%s; %s changed.", owner, repo, repoPromptsDir)
+		s.forgetRepoPrompts(owner + "/" + repo)
+	}
+
+	cfg, err := s.repoConfig(ctx, installationID, owner, repo)
+	if err != nil {
+		return fmt.Errorf("loading repo config: %v", err)
+	}
+	if len(cfg.Push.Tasks) == 0 {
+		return nil
+	}
+
+	// The agent's own pushes must not trigger push tasks, or a task that
+	// changes matching paths would run forever.
+	if s.isDevBranch(branch) {
+		s.Log.Debug(ctx, "Ignoring push to %s (repo %s/%s); it is a runner branch.", branch, owner, repo)
+		return nil
+	}
+	botLogin, err := s.appBotLogin(ctx)
+	if err != nil {
+		return err
+	}
+	if event.GetSender().GetLogin() == botLogin {
+		s.Log.Debug(ctx, "Ignoring push to %s (repo %s/%s); pushed by %s.", branch, owner, repo, botLogin)
+		return nil
+	}
+
+	var ghRepo *github.Repository
+	var errs []error
+	for _, task := range cfg.Push.Tasks {
+		if !task.matchesBranch(branch, event.GetRepo().GetDefaultBranch()) {
+			continue
+		}
+		matched := task.matchingPaths(changed)
+		if len(matched) == 0 {
+			s.Log.Debug(ctx, "Skipping push task %q (repo %s/%s); no matching paths changed.", task.Name, owner, repo)
+			continue
+		}
+
+		if ghRepo == nil {
+			ghClient, err := s.githubClient(ctx, installationID)
+			if err != nil {
+				return fmt.Errorf("creating github client: %v", err)
+			}
+			if ghRepo, _, err = ghClient.Repositories.GetByID(ctx, event.GetRepo().GetID()); err != nil {
+				return fmt.Errorf("getting repo %s/%s: %w", owner, repo, err)
+			}
+			pr, err := s.agentPullRequest(ctx, ghClient, owner, repo, event.GetAfter(), botLogin)
+			if err != nil {
+				return err
+			}
+			if pr != nil {
+				s.Log.Debug(ctx, "Ignoring push to %s (repo %s/%s); it merges pull request %d opened by a runner.", branch, owner, repo, pr.GetNumber())
+				return nil
+			}
+		}
+
+		prompt, err := s.renderPrompt(ctx, installationID, owner, repo, &promptPush{task: task, branch: branch, changedFiles: changed, matchedFiles: matched, event: event})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("push task %q: rendering prompt: %v", task.Name, err))
+			continue
+		}
+		s.Log.Info(ctx, "Running push task %q for %s/%s@%s", task.Name, owner, repo, shortSHA(event.GetAfter()))
+		trigger := jobTrigger{name: triggerPush, url: event.GetCompare()}
+		if err := s.run(ctx, trigger, installationID, ghRepo, prompt, withBranch(branch)); err != nil {
+			errs = append(errs, fmt.Errorf("push task %q: %w", task.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// agentPullRequest returns the merged pull request that introduced commit sha,
+// if it was opened by the app or from a runner branch, and nil otherwise.
+func (s *Service) agentPullRequest(ctx context.Context, ghClient *github.Client, owner, repo, sha, botLogin string) (*github.PullRequest, error) {
+	prs, _, err := ghClient.PullRequests.ListPullRequestsWithCommit(ctx, owner, repo, sha, nil)
+	if err != nil {
+		return nil, fmt.Errorf("listing pull requests with commit %s: %w", shortSHA(sha), err)
+	}
+	for _, pr := range prs {
+		if pr.GetMergedAt().IsZero() {
+			continue
+		}
+		if pr.GetUser().GetLogin() == botLogin || s.isDevBranch(pr.GetHead().GetRef()) {
+			return pr, nil
+		}
+	}
+	return nil, nil
+}
+
+// pushedFiles returns the sorted set of files added, modified or removed by the
+// commits of a push.
+func pushedFiles(event *github.PushEvent) []string {
+	var files []string
+	for _, c := range event.Commits {
+		files = append(files, c.Added...)
+		files = append(files, c.Modified...)
+		files = append(files, c.Removed...)
+	}
+	slices.Sort(files)
+	return slices.Compact(files)
+}
+
+// shortSHA abbreviates a commit SHA for log messages.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// commandAllowed reports whether login, whose comment or review has the given
+// author association, can start an agent in owner/repo: they must be an owner,
+// member or collaborator, or be listed in commands.allowedUsers.
+func (s *Service) commandAllowed(ctx context.Context, installationID int64, owner, repo, login, association string) (bool, error) {
+	if slices.Contains(trustedAssociations, association) {
+		return true, nil
+	}
+	cfg, err := s.repoConfig(ctx, installationID, owner, repo)
+	if err != nil {
+		return false, fmt.Errorf("loading repo config: %v", err)
+	}
+	return slices.ContainsFunc(cfg.Commands.AllowedUsers, func(u string) bool { return strings.EqualFold(u, login) }), nil
+}
+
+// extractServiceCommand returns the command from a "/<service> <command>"
+// first line. Later lines are left for the agent, e.g., to explain a fix.
 func (s *Service) extractServiceCommand(_ context.Context, body string) (string, bool) {
-	cmd, forService := strings.CutPrefix(strings.TrimSpace(body), "/"+s.ServiceName+" ")
+	line, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+	cmd, forService := strings.CutPrefix(line, "/"+s.ServiceName+" ")
 	cmd = strings.TrimSpace(cmd)
 	return cmd, forService
 }
@@ -38,28 +38,37 @@ func (s *Service) fork(ctx context.Context, installationID int64, owner, repo st
 	fork, resp, err := ghClient.Repositories.CreateFork(ctx, owner, repo, opts)
 	if err != nil {
 		if _, ok := err.(*github.AcceptedError); !ok {
-			return nil, fmt.Errorf("creating fork (status code: %d): %v", resp.StatusCode, err)
+			return nil, fmt.Errorf("creating fork (status code: %d): %v", statusCode(resp), err)
 		}
 	}
 
-	attempt := 1
-	for {
-		if attempt > maxForkWaitAttempts {
-			return nil, fmt.Errorf("failed to wait for fork after %d attempts", attempt)
-		}
+	// Forking happens asynchronously; poll until the fork is visible.
+	for attempt := 1; ; attempt++ {
 		repoObj, resp, err := ghClient.Repositories.Get(ctx, fork.GetOwner().GetLogin(), fork.GetName())
-		if err != nil {
-			return nil, fmt.Errorf("getting fork: %v", err)
-		}
-		if resp.StatusCode == http.StatusOK {
+		switch {
+		case statusCode(resp) == http.StatusNotFound:
+			// Not ready yet.
+		case err != nil:
+			return nil, fmt.Errorf("getting fork (status code: %d): %v", statusCode(resp), err)
+		default:
 			s.Log.Debug(ctx, "Fork %s/%s found", fork.GetOwner().GetLogin(), fork.GetName())
 			return repoObj, nil
 		}
-		if resp.StatusCode == http.StatusNotFound {
-			time.Sleep(waitForFork)
-			attempt++
-			continue
+
+		if attempt >= maxForkWaitAttempts {
+			return nil, fmt.Errorf("failed to wait for fork after %d attempts", attempt)
 		}
-		return nil, fmt.Errorf("getting fork (status code: %d): %v", resp.StatusCode, err)
+		if err := sleepCtx(ctx, waitForFork); err != nil {
+			return nil, fmt.Errorf("waiting for fork: %w", err)
+		}
+	}
+}
+
+// statusCode returns the HTTP status of a GitHub response, or 0 if there was
+// no response (e.g., a network error).
+func statusCode(resp *github.Response) int {
+	if resp == nil || resp.Response == nil {
+		return 0
 	}
+	return resp.StatusCode
 }
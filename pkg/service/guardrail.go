@@ -0,0 +1,141 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/squee1945/pillar-service/pkg/jobs"
+)
+
+// Prompt injection policies, set per repository in .pillar.yaml.
+const (
+	injectionPolicyOff    = "off"
+	injectionPolicyFlag   = "flag"
+	injectionPolicyRefuse = "refuse"
+
+	untrustedTag       = "untrusted-content"
+	maxGuardFindings   = 20
+	maxExcerptRunes    = 120
+	excerptContextSize = 40
+)
+
+var injectionPolicies = []string{injectionPolicyOff, injectionPolicyFlag, injectionPolicyRefuse}
+
+// injectionPatterns are known prompt injection techniques. They are
+// deliberately narrow: a match flags content for a human to look at, it does
+// not prove an attack.
+var injectionPatterns = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"override instructions", regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b.{0,30}\b(previous|prior|above|earlier|all|system|your)\b.{0,20}\b(instructions?|prompts?|rules|directions)\b`)},
+	{"role change", regexp.MustCompile(`(?i)\byou are (now|no longer)\b|\bnew (system )?instructions\s*:|\bfrom now on,? you\b`)},
+	{"role markers", regexp.MustCompile(`(?i)</?\s*(system|assistant|developer)\s*>|\[/?(INST|SYS)\]|<\|im_(start|end)\|>`)},
+	{"secret exfiltration", regexp.MustCompile(`(?i)\b(reveal|send|post|leak|exfiltrate|upload|print)\b.{0,40}\b(tokens?|secrets?|credentials?|api[ _-]?keys?|passwords?)\b.{0,40}\b(to|into|at|on)\b`)},
+	{"remote script", regexp.MustCompile(`(?i)\b(curl|wget)\b[^\n|]{0,200}\|\s*(ba|z)?sh\b`)},
+	{"fence spoofing", regexp.MustCompile(`(?i)</?\s*` + untrustedTag)},
+	{"hidden characters", regexp.MustCompile(`[\x{200B}-\x{200F}\x{202A}-\x{202E}\x{2060}-\x{2064}\x{2066}-\x{2069}\x{FEFF}\x{E0000}-\x{E007F}]`)},
+}
+
+// jsonEscapes undoes the escaping of whitespace and quotes in JSON strings, so
+// that patterns match across them.
+var jsonEscapes = strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\"`, `"`)
+
+// promptGuard fences untrusted content in a prompt and scans it for known
+// injection patterns. It backs the untrusted template function for a single
+// render.
+type promptGuard struct {
+	policy   string
+	nonce    string
+	findings []jobs.Finding
+}
+
+func newPromptGuard(policy string) *promptGuard {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b) // Never returns an error.
+	return &promptGuard{policy: policy, nonce: hex.EncodeToString(b)}
+}
+
+// untrusted is the untrusted template function. It wraps s, authored by
+// someone other than the repository's maintainers, in a block delimited by
+// tags carrying a per-render nonce, so that the content cannot close the block
+// itself.
+func (g *promptGuard) untrusted(label, s string) string {
+	var found []string
+	if g.policy != injectionPolicyOff {
+		text := jsonEscapes.Replace(s)
+		for _, p := range injectionPatterns {
+			loc := p.re.FindStringIndex(text)
+			if loc == nil {
+				continue
+			}
+			found = append(found, p.name)
+			if len(g.findings) < maxGuardFindings {
+				g.findings = append(g.findings, jobs.Finding{Content: label, Pattern: p.name, Excerpt: excerpt(text, loc)})
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%s id=%q label=%q", untrustedTag, g.nonce, label)
+	if len(found) > 0 {
+		fmt.Fprintf(&b, " flagged=%q", strings.Join(found, ", "))
+	}
+	fmt.Fprintf(&b, ">\n%s\n</%s id=%q>", s, untrustedTag, g.nonce)
+	return b.String()
+}
+
+// reset discards the findings of a previous attempt at rendering.
+func (g *promptGuard) reset() {
+	g.findings = nil
+}
+
+// guardrail returns the decision for the rendered prompt.
+func (g *promptGuard) guardrail() *jobs.Guardrail {
+	gr := &jobs.Guardrail{Policy: g.policy, Findings: slices.Clone(g.findings)}
+	switch {
+	case g.policy == injectionPolicyOff:
+		gr.Decision = jobs.DecisionUnscanned
+	case len(g.findings) == 0:
+		gr.Decision = jobs.DecisionClean
+	case g.policy == injectionPolicyRefuse:
+		gr.Decision = jobs.DecisionRefused
+	default:
+		gr.Decision = jobs.DecisionFlagged
+	}
+	return gr
+}
+
+// excerpt returns the match at loc in s with some context, with invisible
+// characters made visible.
+func excerpt(s string, loc []int) string {
+	start, end := max(loc[0]-excerptContextSize, 0), min(loc[1]+excerptContextSize, len(s))
+	r := []rune(strings.ToValidUTF8(s[start:end], ""))
+	if len(r) > maxExcerptRunes {
+		r = r[:maxExcerptRunes]
+	}
+	var b strings.Builder
+	for _, c := range r {
+		switch {
+		case c == '\n' || c == '\r' || c == '\t':
+			b.WriteRune(' ')
+		case c < ' ' || invisible(c):
+			fmt.Fprintf(&b, "<U+%04X>", c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// invisible reports whether c is a zero-width, bidirectional control or tag
+// character, which can hide text from a human reviewer.
+func invisible(c rune) bool {
+	return (c >= 0x200B && c <= 0x200F) || (c >= 0x202A && c <= 0x202E) ||
+		(c >= 0x2060 && c <= 0x2064) || (c >= 0x2066 && c <= 0x2069) ||
+		c == 0xFEFF || (c >= 0xE0000 && c <= 0xE007F)
+}
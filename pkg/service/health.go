@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	readinessTimeout = 5 * time.Second
+)
+
+// Drain marks the service as shutting down; /readyz fails from this point on
+// so that no new traffic is routed here while in-flight requests complete.
+func (s *Service) Drain() {
+	s.draining.Store(true)
+}
+
+// Flush runs the pending release batches and debounced populate-pr runs
+// without waiting for their windows, and waits for them and other background
+// work such as onboarding within ctx, once the HTTP server has shut down.
+func (s *Service) Flush(ctx context.Context) {
+	if fns := s.debouncer.flushPrefix(pullRequestDebounceKeyPrefix); len(fns) > 0 {
+		s.Log.Info(ctx, "Running %d debounced populate-pr run(s) early", len(fns))
+		for _, fn := range fns {
+			s.background.Go(fn)
+		}
+	}
+	s.flushReleaseBatches(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Log.Warn(context.Background(), "Gave up waiting for background work: %v", ctx.Err())
+	}
+	if n := s.debouncer.count(); n > 0 {
+		s.Log.Warn(context.Background(), "Draining with %d debounced run(s) still pending; they will not run", n)
+	}
+}
+
+func (s *Service) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+func (s *Service) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.ready(r.Context()); err != nil {
+		s.Log.Warn(r.Context(), "Readiness check failed: %v", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("not ready: " + err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+func (s *Service) ready(ctx context.Context) error {
+	if s.draining.Load() {
+		return fmt.Errorf("draining")
+	}
+	if s.prompts == nil {
+		return fmt.Errorf("prompt templates not parsed")
+	}
+	if s.dashboard == nil {
+		return fmt.Errorf("dashboard templates not parsed")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	if _, err := s.Secrets.Read(ctx, s.WebhookSecretName); err != nil {
+		return fmt.Errorf("reading webhook secret: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/squee1945/pillar-service/pkg/jobs"
+)
+
+//go:embed onboarding/pillar.yaml
+var onboardingConfig string
+
+func (s *Service) installationEventHandler(ctx context.Context, event *github.InstallationEvent) error {
+	inst := event.GetInstallation()
+	installationID := inst.GetID()
+
+	switch action := event.GetAction(); action {
+	case "created":
+		repos := jobsRepos(event.Repositories)
+		if _, err := s.Jobs.UpdateInstallation(ctx, installationID, func(i *jobs.Installation) error {
+			i.Account = inst.GetAccount().GetLogin()
+			i.Suspended = false
+			i.Repos = repos
+			return nil
+		}); err != nil {
+			return fmt.Errorf("recording installation: %v", err)
+		}
+		s.Log.Info(ctx, "App installed on %s (installation %d, %d repositories)", inst.GetAccount().GetLogin(), installationID, len(repos))
+		s.onboard(ctx, installationID, event.Repositories)
+
+	case "deleted":
+		s.invalidateInstallation(ctx, installationID)
+		s.forgetRepos(ctx, installationID, nil)
+		if err := s.Jobs.DeleteInstallation(ctx, installationID); err != nil && !errors.Is(err, jobs.ErrNotFound) {
+			return fmt.Errorf("deleting installation: %v", err)
+		}
+		s.Log.Info(ctx, "App uninstalled from %s (installation %d)", inst.GetAccount().GetLogin(), installationID)
+
+	case "suspend", "unsuspend":
+		if action == "suspend" {
+			s.invalidateInstallation(ctx, installationID)
+		}
+		if _, err := s.Jobs.UpdateInstallation(ctx, installationID, func(i *jobs.Installation) error {
+			i.Account = inst.GetAccount().GetLogin()
+			i.Suspended = action == "suspend"
+			return nil
+		}); err != nil {
+			return fmt.Errorf("recording installation: %v", err)
+		}
+
+	case "new_permissions_accepted":
+		// Cached tokens carry the old permissions.
+		s.invalidateInstallation(ctx, installationID)
+
+	default:
+		s.Log.Debug(ctx, "Ignoring installation %s event (installation %d)", action, installationID)
+	}
+	return nil
+}
+
+func (s *Service) installationRepositoriesEventHandler(ctx context.Context, event *github.InstallationRepositoriesEvent) error {
+	inst := event.GetInstallation()
+	installationID := inst.GetID()
+	added, removed := jobsRepos(event.RepositoriesAdded), jobsRepos(event.RepositoriesRemoved)
+
+	if _, err := s.Jobs.UpdateInstallation(ctx, installationID, func(i *jobs.Installation) error {
+		i.Account = inst.GetAccount().GetLogin()
+		i.Repos = slices.DeleteFunc(i.Repos, func(r jobs.Repo) bool {
+			return slices.ContainsFunc(removed, func(rr jobs.Repo) bool { return rr.ID == r.ID }) ||
+				slices.ContainsFunc(added, func(ar jobs.Repo) bool { return ar.ID == r.ID })
+		})
+		i.Repos = append(i.Repos, added...)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("recording installation: %v", err)
+	}
+	s.Log.Info(ctx, "Installation %d repositories changed (%d added, %d removed)", installationID, len(added), len(removed))
+
+	if len(removed) > 0 {
+		// Tokens may be scoped to the removed repositories.
+		s.invalidateInstallation(ctx, installationID)
+		s.forgetRepos(ctx, installationID, removed)
+	}
+	s.onboard(ctx, installationID, event.RepositoriesAdded)
+	return nil
+}
+
+// forgetRepos drops cached state for repos of an installation; nil means all
+// of its recorded repos.
+func (s *Service) forgetRepos(ctx context.Context, installationID int64, repos []jobs.Repo) {
+	if repos == nil {
+		list, err := s.Jobs.ListInstallations(ctx)
+		if err != nil {
+			s.Log.Warn(ctx, "Failed to list installations, continuing: %v", err)
+		}
+		for _, i := range list {
+			if i.ID == installationID {
+				repos = i.Repos
+			}
+		}
+	}
+
+	s.repoConfigs.mu.Lock()
+	for _, r := range repos {
+		delete(s.repoConfigs.entries, r.FullName)
+	}
+	s.repoConfigs.mu.Unlock()
+
+	for _, r := range repos {
+		s.forgetRepoPrompts(r.FullName)
+	}
+	for _, r := range repos {
+		if n := s.debouncer.cancelPrefix(pullRequestDebounceKeyPrefix + r.FullName + "#"); n > 0 {
+			s.Log.Info(ctx, "Cancelled %d debounced run(s) for %s", n, r.FullName)
+		}
+	}
+}
+
+// onboard opens a pull request proposing a default .pillar.yaml in each repo
+// that does not have one yet. It runs in the background, since an installation
+// may cover many repositories; failures are logged. Once the service is
+// draining, the remaining repos are skipped.
+func (s *Service) onboard(ctx context.Context, installationID int64, repos []*github.Repository) {
+	if s.SkipOnboarding || len(repos) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	s.background.Go(func() {
+		for i, repo := range repos {
+			if s.draining.Load() {
+				s.Log.Warn(ctx, "Draining; not onboarding %d remaining repo(s) of installation %d", len(repos)-i, installationID)
+				return
+			}
+			if err := s.onboardRepo(ctx, installationID, repo.GetID(), repo.GetFullName()); err != nil {
+				s.Log.Warn(ctx, "Failed to onboard %s, continuing: %v", repo.GetFullName(), err)
+			}
+		}
+	})
+}
+
+func (s *Service) onboardRepo(ctx context.Context, installationID, repoID int64, fullName string) error {
+	owner, name, _ := strings.Cut(fullName, "/")
+
+	token, err := s.installationToken(ctx, installationID, withRepoIDs(repoID), withPermissions(onboardingPermissions))
+	if err != nil {
+		return fmt.Errorf("generating installation token: %v", err)
+	}
+	ghClient := github.NewClient(&http.Client{Transport: s.githubTransport}).WithAuthToken(token)
+
+	_, _, resp, err := ghClient.Repositories.GetContents(ctx, owner, name, repoConfigPath, nil)
+	switch {
+	case err == nil:
+		s.Log.Debug(ctx, "Not onboarding %s; it already has %s.", fullName, repoConfigPath)
+		return nil
+	case statusCode(resp) != http.StatusNotFound:
+		return fmt.Errorf("getting %s: %w", repoConfigPath, err)
+	}
+
+	repo, _, err := ghClient.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		return fmt.Errorf("getting repo: %w", err)
+	}
+	base := repo.GetDefaultBranch()
+	ref, _, err := ghClient.Git.GetRef(ctx, owner, name, "heads/"+base)
+	if err != nil {
+		return fmt.Errorf("getting %s: %w", base, err)
+	}
+
+	branch := s.ServiceName + "/onboarding"
+	_, resp, err = ghClient.Git.CreateRef(ctx, owner, name, github.CreateRef{Ref: "refs/heads/" + branch, SHA: ref.GetObject().GetSHA()})
+	if statusCode(resp) == http.StatusUnprocessableEntity {
+		// The branch exists, so onboarding has been proposed before.
+		s.Log.Debug(ctx, "Not onboarding %s; branch %s already exists.", fullName, branch)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("creating branch %s: %w", branch, err)
+	}
+
+	content := strings.ReplaceAll(onboardingConfig, "{{SERVICE}}", s.ServiceName)
+	if _, _, err := ghClient.Repositories.CreateFile(ctx, owner, name, repoConfigPath, &github.RepositoryContentFileOptions{
+		Message: github.Ptr("Add " + repoConfigPath),
+		Content: []byte(content),
+		Branch:  github.Ptr(branch),
+	}); err != nil {
+		return fmt.Errorf("creating %s: %w", repoConfigPath, err)
+	}
+
+	pr, _, err := ghClient.PullRequests.Create(ctx, owner, name, &github.NewPullRequest{
+		Title: github.Ptr("Configure " + s.ServiceName),
+		Head:  github.Ptr(branch),
+		Base:  github.Ptr(base),
+		Body: github.Ptr(fmt.Sprintf("The %[1]s GitHub app has been installed on this repository. "+
+			"This adds a %[2]s with the available settings. Only release upgrades are on by default, for published releases; "+
+			"the other features are disabled. Edit it to enable the features you want, then merge; or close this pull request to keep the defaults.", s.ServiceName, "`"+repoConfigPath+"`")),
+	})
+	if err != nil {
+		return fmt.Errorf("creating pull request: %w", err)
+	}
+	s.Log.Info(ctx, "Opened onboarding pull request %s", pr.GetHTMLURL())
+	return nil
+}
+
+func jobsRepos(repos []*github.Repository) []jobs.Repo {
+	out := make([]jobs.Repo, 0, len(repos))
+	for _, r := range repos {
+		out = append(out, jobs.Repo{ID: r.GetID(), FullName: r.GetFullName()})
+	}
+	return out
+}
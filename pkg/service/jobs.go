@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
+	"github.com/squee1945/pillar-service/pkg/jobs"
+	"github.com/squee1945/pillar-service/pkg/runner"
+)
+
+var errJobState = errors.New("job is not in a valid state for this operation")
+
+// refreshJob updates a running job's status from its latest Cloud Build build.
+func (s *Service) refreshJob(ctx context.Context, job *jobs.Job) (*jobs.Job, error) {
+	buildID := job.LatestBuildID()
+	if job.Status != jobs.StatusRunning || buildID == "" {
+		return job, nil
+	}
+
+	build, err := runner.GetBuild(ctx, s.ProjectID, s.Region, buildID)
+	if err != nil {
+		return nil, err
+	}
+	return s.updateJobFromBuild(ctx, job, build)
+}
+
+// refreshJobs is refreshJob for a list of jobs, fetching the builds of the
+// running ones together. Jobs that cannot be refreshed are left as they are.
+func (s *Service) refreshJobs(ctx context.Context, list []*jobs.Job) []*jobs.Job {
+	var buildIDs []string
+	for _, j := range list {
+		if j.Status == jobs.StatusRunning && j.LatestBuildID() != "" {
+			buildIDs = append(buildIDs, j.LatestBuildID())
+		}
+	}
+	if len(buildIDs) == 0 {
+		return list
+	}
+
+	builds, err := runner.GetBuilds(ctx, s.ProjectID, s.Region, buildIDs)
+	if err != nil {
+		s.Log.Warn(ctx, "Failed to refresh %d running job(s), continuing: %v", len(buildIDs), err)
+		return list
+	}
+	refreshed := make([]*jobs.Job, len(list))
+	for i, j := range list {
+		refreshed[i] = j
+		build, ok := builds[j.LatestBuildID()]
+		if j.Status != jobs.StatusRunning || !ok {
+			continue
+		}
+		if r, err := s.updateJobFromBuild(ctx, j, build); err != nil {
+			s.Log.Warn(ctx, "Failed to refresh job %s, continuing: %v", j.ID, err)
+		} else {
+			refreshed[i] = r
+		}
+	}
+	return refreshed
+}
+
+// updateJobFromBuild records the status of build, the latest build of a
+// running job.
+func (s *Service) updateJobFromBuild(ctx context.Context, job *jobs.Job, build *cloudbuildpb.Build) (*jobs.Job, error) {
+	buildID := build.GetId()
+	status := jobStatus(build.GetStatus())
+	if status == job.Status {
+		return job, nil
+	}
+	return s.Jobs.Update(ctx, job.ID, func(j *jobs.Job) error {
+		// Only move forward; the job may have been cancelled or retried meanwhile.
+		if j.Status != jobs.StatusRunning || j.LatestBuildID() != buildID {
+			return nil
+		}
+		j.Status = status
+		if status == jobs.StatusFailed {
+			j.Error = fmt.Sprintf("build %s: %s", build.GetStatus(), build.GetStatusDetail())
+		}
+		return nil
+	})
+}
+
+// retryJob launches a new runner build for a failed or cancelled job, using a
+// fresh token and dev branch but the original prompt and settings. The job is
+// moved to PENDING first, so that concurrent retries launch a single build.
+func (s *Service) retryJob(ctx context.Context, id string) (*jobs.Job, error) {
+	var prev jobs.Status
+	job, err := s.Jobs.Update(ctx, id, func(j *jobs.Job) error {
+		if j.Status != jobs.StatusFailed && j.Status != jobs.StatusCancelled {
+			return fmt.Errorf("%w: cannot retry job in status %s", errJobState, j.Status)
+		}
+		prev = j.Status
+		j.Status = jobs.StatusPending
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := s.retryConfig(ctx, job)
+	if err != nil {
+		if _, uerr := s.Jobs.Update(ctx, job.ID, func(j *jobs.Job) error {
+			j.Status = jobs.StatusFailed
+			j.Error = err.Error()
+			return nil
+		}); uerr != nil {
+			s.Log.Warn(ctx, "Failed to record outcome of job %s (was %s), continuing: %v", job.ID, prev, uerr)
+		}
+		return nil, err
+	}
+
+	s.Log.Info(ctx, "Retrying job %s (%s) against %s", job.ID, job.Trigger, job.FullName())
+	if err := s.launch(ctx, job.ID, cfg); err != nil {
+		return nil, err
+	}
+	return s.Jobs.Get(ctx, job.ID)
+}
+
+// retryConfig returns the runner config to retry job with.
+func (s *Service) retryConfig(ctx context.Context, job *jobs.Job) (runner.Config, error) {
+	perms, err := permissionsFor(job.Trigger)
+	if err != nil {
+		return runner.Config{}, err
+	}
+
+	ghClient, err := s.githubClient(ctx, job.InstallationID)
+	if err != nil {
+		return runner.Config{}, fmt.Errorf("creating github client: %v", err)
+	}
+	repo, _, err := ghClient.Repositories.GetByID(ctx, job.RepoID)
+	if err != nil {
+		return runner.Config{}, fmt.Errorf("getting repo %s: %w", job.FullName(), err)
+	}
+
+	cfg, err := s.runnerConfig(ctx, job.InstallationID, repo, perms)
+	if err != nil {
+		return runner.Config{}, fmt.Errorf("generating runner config: %v", err)
+	}
+	withSettingsFrom(job.Settings)(&cfg)
+	cfg.Prompt = job.Prompt
+	return cfg, nil
+}
+
+// cancelJob cancels the latest runner build of a running job. The job is
+// moved to CANCELLING first, so that concurrent cancellations cancel once.
+func (s *Service) cancelJob(ctx context.Context, id string) (*jobs.Job, error) {
+	var buildID string
+	job, err := s.Jobs.Update(ctx, id, func(j *jobs.Job) error {
+		buildID = j.LatestBuildID()
+		if j.Status != jobs.StatusRunning || buildID == "" {
+			return fmt.Errorf("%w: cannot cancel job in status %s", errJobState, j.Status)
+		}
+		j.Status = jobs.StatusCancelling
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cancelErr := runner.CancelBuild(ctx, s.ProjectID, s.Region, buildID)
+	if cancelErr == nil {
+		s.Log.Info(ctx, "Cancelled job %s (build %s)", job.ID, buildID)
+	}
+	job, err = s.Jobs.Update(ctx, job.ID, func(j *jobs.Job) error {
+		if j.Status != jobs.StatusCancelling {
+			return nil
+		}
+		if cancelErr != nil {
+			j.Status = jobs.StatusRunning // The build is still running.
+			return nil
+		}
+		j.Status = jobs.StatusCancelled
+		return nil
+	})
+	if cancelErr != nil {
+		return nil, cancelErr
+	}
+	return job, err
+}
+
+func jobStatus(status cloudbuildpb.Build_Status) jobs.Status {
+	switch status {
+	case cloudbuildpb.Build_SUCCESS:
+		return jobs.StatusSucceeded
+	case cloudbuildpb.Build_FAILURE, cloudbuildpb.Build_INTERNAL_ERROR, cloudbuildpb.Build_TIMEOUT, cloudbuildpb.Build_EXPIRED:
+		return jobs.StatusFailed
+	case cloudbuildpb.Build_CANCELLED:
+		return jobs.StatusCancelled
+	default:
+		return jobs.StatusRunning
+	}
+}
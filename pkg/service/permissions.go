@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v75/github"
+)
+
+const (
+	triggerReleasePublished = "release/published"
+	triggerPopulatePR       = "issue_comment/" + cmdPopulatePR
+	triggerAutoPopulatePR   = "pull_request/" + cmdPopulatePR
+	triggerPush             = "push"
+	triggerImplementLabel   = "issues/labeled/" + cmdImplement
+	triggerImplementComment = "issue_comment/" + cmdImplement
+	triggerFixReviewComment = "pull_request_review_comment/" + cmdFix
+	triggerFixReview        = "pull_request_review/" + cmdFix
+
+	triggerReleasePrereleased = "release/prereleased"
+	triggerReleaseReleased    = "release/released"
+	triggerReleaseEdited      = "release/edited"
+	triggerCreateTag          = "create/tag"
+
+	triggerTriageWorkflowRun    = "workflow_run/triage"
+	triggerTriageCheckRun       = "check_run/triage"
+	triggerTriageFixWorkflowRun = "workflow_run/triage-fix"
+	triggerTriageFixCheckRun    = "check_run/triage-fix"
+)
+
+// triggerPermissions declares the GitHub permissions that the runner's token
+// needs for each trigger. Runner tokens are restricted to exactly these (and
+// to the target repository), so every trigger passed to run must be listed.
+var triggerPermissions = map[string]*github.InstallationPermissions{
+	triggerReleasePublished:   releasePermissions,
+	triggerReleasePrereleased: releasePermissions,
+	triggerReleaseReleased:    releasePermissions,
+	triggerReleaseEdited:      releasePermissions,
+	triggerCreateTag:          releasePermissions,
+	// Reads the pull request and posts a summary comment; never pushes.
+	triggerPopulatePR: {
+		Metadata:     github.Ptr("read"),
+		Contents:     github.Ptr("read"),
+		PullRequests: github.Ptr("write"),
+		Issues:       github.Ptr("write"),
+	},
+	// Same as populate-pr, but triggered by pull_request events when enabled
+	// in the repository's .pillar.yaml.
+	triggerAutoPopulatePR: {
+		Metadata:     github.Ptr("read"),
+		Contents:     github.Ptr("read"),
+		PullRequests: github.Ptr("write"),
+		Issues:       github.Ptr("write"),
+	},
+	// Runs a repository's push tasks, which may push a branch and open a pull
+	// request with the result.
+	triggerPush: {
+		Metadata:     github.Ptr("read"),
+		Contents:     github.Ptr("write"),
+		PullRequests: github.Ptr("write"),
+		Actions:      github.Ptr("read"),
+		Checks:       github.Ptr("read"),
+		Statuses:     github.Ptr("read"),
+	},
+	triggerImplementLabel:   implementPermissions,
+	triggerImplementComment: implementPermissions,
+	triggerFixReviewComment: fixPermissions,
+	triggerFixReview:        fixPermissions,
+
+	triggerTriageWorkflowRun:    triagePermissions,
+	triggerTriageCheckRun:       triagePermissions,
+	triggerTriageFixWorkflowRun: fixPermissions,
+	triggerTriageFixCheckRun:    fixPermissions,
+}
+
+// releasePermissions let the runner upgrade a dependent: push a branch and
+// open/update a pull request, then watch the workflows that run on it.
+var releasePermissions = &github.InstallationPermissions{
+	Metadata:     github.Ptr("read"),
+	Contents:     github.Ptr("write"),
+	PullRequests: github.Ptr("write"),
+	Actions:      github.Ptr("read"),
+	Checks:       github.Ptr("read"),
+	Statuses:     github.Ptr("read"),
+}
+
+// implementPermissions lets the runner push a branch, open a draft pull
+// request, watch its workflows and comment on the issue being implemented.
+var implementPermissions = &github.InstallationPermissions{
+	Metadata:     github.Ptr("read"),
+	Contents:     github.Ptr("write"),
+	PullRequests: github.Ptr("write"),
+	Issues:       github.Ptr("write"),
+	Actions:      github.Ptr("read"),
+	Checks:       github.Ptr("read"),
+	Statuses:     github.Ptr("read"),
+}
+
+// fixPermissions lets the runner push to a pull request's head branch, reply
+// to review comments and watch the resulting workflows.
+var fixPermissions = &github.InstallationPermissions{
+	Metadata:     github.Ptr("read"),
+	Contents:     github.Ptr("write"),
+	PullRequests: github.Ptr("write"),
+	Actions:      github.Ptr("read"),
+	Checks:       github.Ptr("read"),
+	Statuses:     github.Ptr("read"),
+}
+
+// triagePermissions lets the runner read a pull request and its CI results,
+// and comment with a diagnosis; it never pushes.
+var triagePermissions = &github.InstallationPermissions{
+	Metadata:     github.Ptr("read"),
+	Contents:     github.Ptr("read"),
+	PullRequests: github.Ptr("write"),
+	Issues:       github.Ptr("write"),
+	Actions:      github.Ptr("read"),
+	Checks:       github.Ptr("read"),
+	Statuses:     github.Ptr("read"),
+}
+
+// onboardingPermissions are used by the service itself (not a runner) to
+// propose a default .pillar.yaml when the app is installed.
+var onboardingPermissions = &github.InstallationPermissions{
+	Metadata:     github.Ptr("read"),
+	Contents:     github.Ptr("write"),
+	PullRequests: github.Ptr("write"),
+}
+
+func permissionsFor(trigger string) (*github.InstallationPermissions, error) {
+	perms, ok := triggerPermissions[trigger]
+	if !ok {
+		return nil, fmt.Errorf("no permissions declared for trigger %q", trigger)
+	}
+	return perms, nil
+}
+
+// CheckPermissions verifies that the GitHub app has been granted every
+// permission declared in triggerPermissions, reporting all shortfalls at once.
+func (s *Service) CheckPermissions(ctx context.Context) error {
+	_, appClient, err := s.appsTransport(ctx)
+	if err != nil {
+		return err
+	}
+
+	app, _, err := appClient.Apps.Get(ctx, "")
+	if err != nil {
+		return fmt.Errorf("getting app: %v", err)
+	}
+
+	var errs []error
+	for _, trigger := range slices.Sorted(maps.Keys(triggerPermissions)) {
+		for _, missing := range missingPermissions(triggerPermissions[trigger], app.GetPermissions()) {
+			errs = append(errs, fmt.Errorf("trigger %s: %s", trigger, missing))
+		}
+	}
+	if !s.SkipOnboarding {
+		for _, missing := range missingPermissions(onboardingPermissions, app.GetPermissions()) {
+			errs = append(errs, fmt.Errorf("onboarding: %s", missing))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var permissionLevels = map[string]int{"": 0, "read": 1, "write": 2, "admin": 3}
+
+// missingPermissions lists each permission in want that granted does not cover.
+func missingPermissions(want, granted *github.InstallationPermissions) []string {
+	if want == nil {
+		return nil
+	}
+	if granted == nil {
+		granted = &github.InstallationPermissions{}
+	}
+
+	var missing []string
+	wv, gv := reflect.ValueOf(want).Elem(), reflect.ValueOf(granted).Elem()
+	for i := range wv.NumField() {
+		w, ok := wv.Field(i).Interface().(*string)
+		if !ok || w == nil {
+			continue
+		}
+		var g string
+		if p, ok := gv.Field(i).Interface().(*string); ok && p != nil {
+			g = *p
+		}
+		if permissionLevels[g] < permissionLevels[*w] {
+			name, _, _ := strings.Cut(wv.Type().Field(i).Tag.Get("json"), ",")
+			missing = append(missing, fmt.Sprintf("needs %s:%s, app has %q", name, *w, g))
+		}
+	}
+	return missing
+}
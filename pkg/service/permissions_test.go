@@ -0,0 +1,60 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v75/github"
+)
+
+func TestMissingPermissions(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    *github.InstallationPermissions
+		granted *github.InstallationPermissions
+		missing []string
+	}{
+		{name: "nothing wanted", granted: &github.InstallationPermissions{Contents: github.Ptr("write")}},
+		{
+			name:    "nothing granted",
+			want:    &github.InstallationPermissions{Contents: github.Ptr("read")},
+			missing: []string{`needs contents:read, app has ""`},
+		},
+		{
+			name:    "exact",
+			want:    &github.InstallationPermissions{Contents: github.Ptr("write"), Issues: github.Ptr("read")},
+			granted: &github.InstallationPermissions{Contents: github.Ptr("write"), Issues: github.Ptr("read")},
+		},
+		{
+			name:    "higher level covers lower",
+			want:    &github.InstallationPermissions{Contents: github.Ptr("read"), Issues: github.Ptr("write")},
+			granted: &github.InstallationPermissions{Contents: github.Ptr("write"), Issues: github.Ptr("admin")},
+		},
+		{
+			name:    "lower level",
+			want:    &github.InstallationPermissions{Contents: github.Ptr("write"), Metadata: github.Ptr("read")},
+			granted: &github.InstallationPermissions{Contents: github.Ptr("read"), Metadata: github.Ptr("read")},
+			missing: []string{`needs contents:write, app has "read"`},
+		},
+		{
+			name:    "several missing",
+			want:    &github.InstallationPermissions{PullRequests: github.Ptr("write"), Checks: github.Ptr("read")},
+			granted: &github.InstallationPermissions{Contents: github.Ptr("write")},
+			missing: []string{`needs checks:read, app has ""`, `needs pull_requests:write, app has ""`},
+		},
+		{
+			name:    "extra grants are ignored",
+			want:    &github.InstallationPermissions{Actions: github.Ptr("read")},
+			granted: &github.InstallationPermissions{Actions: github.Ptr("read"), Administration: github.Ptr("write")},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := missingPermissions(tc.want, tc.granted)
+			slices.Sort(got)
+			if !slices.Equal(got, tc.missing) {
+				t.Errorf("missingPermissions() = %q, want %q", got, tc.missing)
+			}
+		})
+	}
+}
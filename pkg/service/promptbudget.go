@@ -0,0 +1,94 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// payloadTrim reduces a GitHub payload to the fields an agent needs: nested
+// users and repositories are replaced by their identifying fields, API links
+// are dropped, and long bodies (release notes, pull request and issue
+// descriptions) are truncated.
+type payloadTrim struct {
+	maxBody int // In runes.
+}
+
+// promptTrimLevels are applied in order until a prompt fits within the budget.
+var promptTrimLevels = []payloadTrim{
+	{maxBody: 8000},
+	{maxBody: 2000},
+	{maxBody: 500},
+}
+
+// userFields and repoFields are kept for users and repositories nested in a
+// payload.
+var (
+	userFields = []string{"login", "type"}
+	repoFields = []string{"full_name", "html_url", "default_branch", "private", "fork"}
+)
+
+// toJSON is the toJSON template function at this trim level.
+func (t payloadTrim) toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("marshalling JSON: %w", err)
+	}
+	var generic any
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return "", fmt.Errorf("unmarshalling JSON: %w", err)
+	}
+	return toJSON(t.trim(generic, true))
+}
+
+func (t payloadTrim) trim(v any, top bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if _, ok := v["login"]; ok && !top {
+			return pick(v, userFields)
+		}
+		if _, ok := v["full_name"]; ok && !top {
+			return pick(v, repoFields)
+		}
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			if k == "_links" || k == "url" || (strings.HasSuffix(k, "_url") && k != "html_url") {
+				continue
+			}
+			if s, ok := e.(string); ok && k == "body" {
+				out[k] = truncateBody(t.maxBody, s)
+				continue
+			}
+			out[k] = t.trim(e, false)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = t.trim(e, top)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// pick returns the keys of m that are listed in fields.
+func pick(m map[string]any, fields []string) map[string]any {
+	out := make(map[string]any, len(fields))
+	for _, f := range fields {
+		if v, ok := m[f]; ok {
+			out[f] = v
+		}
+	}
+	return out
+}
+
+// truncateBody shortens s to n runes, noting how much was cut.
+func truncateBody(n int, s string) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return fmt.Sprintf("%s\n\n[... %d characters truncated]", string(r[:n]), len(r)-n)
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v75/github"
+)
+
+func TestPayloadTrim(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxBody int
+		v       any
+		want    string
+	}{
+		{
+			name:    "nested users and repositories keep their identifying fields",
+			maxBody: 100,
+			v: map[string]any{
+				"action": "opened",
+				"sender": map[string]any{"login": "octocat", "type": "User", "id": 1, "avatar_url": "https://avatars/1", "site_admin": false},
+				"repository": map[string]any{
+					"full_name": "octo/app", "html_url": "https://github.com/octo/app", "default_branch": "main",
+					"private": false, "fork": false, "id": 2, "owner": map[string]any{"login": "octo"}, "topics": []any{"go"},
+				},
+			},
+			want: `{"action": "opened", "sender": {"login": "octocat", "type": "User"},
+				"repository": {"full_name": "octo/app", "html_url": "https://github.com/octo/app", "default_branch": "main", "private": false, "fork": false}}`,
+		},
+		{
+			name:    "top-level user is kept whole",
+			maxBody: 100,
+			v:       map[string]any{"login": "octocat", "id": 1, "name": "Octo Cat"},
+			want:    `{"login": "octocat", "id": 1, "name": "Octo Cat"}`,
+		},
+		{
+			name:    "API links are dropped",
+			maxBody: 100,
+			v: map[string]any{
+				"url": "https://api.github.com/x", "comments_url": "https://api.github.com/x/comments",
+				"html_url": "https://github.com/x", "_links": map[string]any{"self": "x"}, "number": 7,
+			},
+			want: `{"html_url": "https://github.com/x", "number": 7}`,
+		},
+		{
+			name:    "bodies are truncated",
+			maxBody: 5,
+			v:       map[string]any{"body": "héllo world", "title": "a long title that is not a body"},
+			want:    `{"body": "héllo\n\n[... 6 characters truncated]", "title": "a long title that is not a body"}`,
+		},
+		{
+			name:    "short bodies are kept",
+			maxBody: 50,
+			v:       map[string]any{"body": "short"},
+			want:    `{"body": "short"}`,
+		},
+		{
+			name:    "lists are trimmed element by element",
+			maxBody: 3,
+			v:       []any{map[string]any{"body": "abcdef", "user": map[string]any{"login": "a", "id": 3}}},
+			want:    `[{"body": "abc\n\n[... 3 characters truncated]", "user": {"login": "a"}}]`,
+		},
+		{
+			name:    "go-github values",
+			maxBody: 4,
+			v: &github.Issue{
+				Number: github.Ptr(12),
+				Body:   github.Ptr("Please add proxies."),
+				URL:    github.Ptr("https://api.github.com/repos/octo/app/issues/12"),
+				User:   &github.User{Login: github.Ptr("octocat"), ID: github.Ptr(int64(1))},
+			},
+			want: `{"number": 12, "body": "Plea\n\n[... 15 characters truncated]", "user": {"login": "octocat"}}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := payloadTrim{maxBody: tc.maxBody}.toJSON(tc.v)
+			if err != nil {
+				t.Fatalf("toJSON() = %v", err)
+			}
+			var gotV, wantV any
+			if err := json.Unmarshal([]byte(got), &gotV); err != nil {
+				t.Fatalf("toJSON() is not JSON: %v\n%s", err, got)
+			}
+			if err := json.Unmarshal([]byte(tc.want), &wantV); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotV, wantV) {
+				t.Errorf("toJSON() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPromptTrimLevelsShrink(t *testing.T) {
+	v := map[string]any{"body": strings.Repeat("x", 10000)}
+	prev := -1
+	for i, level := range promptTrimLevels {
+		got, err := level.toJSON(v)
+		if err != nil {
+			t.Fatalf("level %d: toJSON() = %v", i, err)
+		}
+		if prev >= 0 && len(got) >= prev {
+			t.Errorf("level %d renders %d bytes, not less than the previous level's %d", i, len(got), prev)
+		}
+		prev = len(got)
+	}
+}
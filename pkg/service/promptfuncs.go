@@ -0,0 +1,84 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"golang.org/x/mod/semver"
+)
+
+// promptFuncs are the helper functions available to prompt templates. The
+// string argument comes last so that they can be used in pipelines, e.g.
+// {{ .Body | truncate 2000 | indent 4 }}.
+var promptFuncs = template.FuncMap{
+	"toJSON":         toJSON,
+	"truncate":       truncate,
+	"indent":         indent,
+	"markdownEscape": markdownEscape,
+	"semverMajor":    semverMajor,
+	"shortSHA":       shortSHA,
+	// untrusted is bound to a promptGuard for each render; see renderPrompt.
+	"untrusted": func(label, s string) string { return s },
+}
+
+// toJSON returns v as indented JSON. Unlike json.Marshal, it leaves <, > and &
+// unescaped, so the agent (and the prompt injection scan) see the text as
+// written.
+func toJSON(v any) (string, error) {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return "", fmt.Errorf("marshalling JSON: %w", err)
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis.
+func truncate(n int, s string) string {
+	r := []rune(s)
+	if n < 0 || len(r) <= n {
+		return s
+	}
+	if n == 0 {
+		return ""
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// indent prefixes each non-empty line of s after the first with n spaces, so
+// that multi-line text stays within a list item.
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	lines := strings.Split(s, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = pad + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`,
+	"\r\n", " ", "\n", " ",
+)
+
+// markdownEscape makes s safe to include inline in markdown, e.g. in a table
+// cell: formatting characters are escaped and newlines become spaces.
+func markdownEscape(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// semverMajor returns the major version of v (e.g. "v2" for "v2.1.0" or
+// "2.1.0"), or "" if v is not a semantic version.
+func semverMajor(v string) string {
+	if !strings.HasPrefix(v, "v") {
+		v = "v" + v
+	}
+	return semver.Major(v)
+}
@@ -4,33 +4,90 @@ import (
 	"bytes"
 	"context"
 	"embed"
-	"encoding/json"
 	"fmt"
 	"text/template"
+	"time"
 
 	"github.com/google/go-github/v75/github"
+	"github.com/squee1945/pillar-service/pkg/jobs"
 )
 
 //go:embed prompts/*.tmpl
 var promptsFS embed.FS
 
+// parsePromptTemplates parses the embedded templates. Files whose names start
+// with "_" are partials, shared by other templates via {{ template "_x.tmpl" }}.
 func parsePromptTemplates(_ context.Context) (*template.Template, error) {
-	return template.ParseFS(promptsFS, "prompts/*.tmpl")
+	return template.New("").Funcs(promptFuncs).ParseFS(promptsFS, "prompts/*.tmpl")
 }
 
-func (s *Service) renderPrompt(ctx context.Context, pt promptTemplate) (string, error) {
+// renderedPrompt is a rendered prompt and the guardrail decision on its
+// untrusted content.
+type renderedPrompt struct {
+	text      string
+	guardrail *jobs.Guardrail
+}
+
+// renderPrompt renders pt with the template overrides for the target
+// repository <owner>/<repo> applied (see promptTemplates), screening its
+// untrusted content according to the repository's prompt injection policy.
+func (s *Service) renderPrompt(ctx context.Context, installationID int64, owner, repo string, pt promptTemplate) (renderedPrompt, error) {
 	tname := pt.Name(ctx) + ".tmpl"
 
 	data, err := pt.Data(ctx)
 	if err != nil {
-		return "", fmt.Errorf("getting prompt data: %w", err)
+		return renderedPrompt{}, fmt.Errorf("getting prompt data: %w", err)
+	}
+
+	tmpl, source, err := s.promptTemplates(ctx, installationID, owner, repo, tname)
+	if err != nil {
+		return renderedPrompt{}, err
+	}
+
+	policy := defaultRepoConfig().PromptInjection.Policy
+	if owner != "" {
+		if cfg, err := s.repoConfig(ctx, installationID, owner, repo); err != nil {
+			s.Log.Warn(ctx, "Failed to load repo config of %s/%s, using the default prompt injection policy %q: %v", owner, repo, policy, err)
+		} else {
+			policy = cfg.PromptInjection.Policy
+		}
 	}
+	guard := newPromptGuard(policy)
+	tmpl.Funcs(template.FuncMap{"untrusted": guard.untrusted})
 
+	// Payloads rendered with toJSON are trimmed further at each level until
+	// the prompt fits within the budget.
 	var buf bytes.Buffer
-	if err := s.prompts.ExecuteTemplate(&buf, tname, data); err != nil {
-		return "", fmt.Errorf("executing template: %w", err)
+	var level int
+	for level = range promptTrimLevels {
+		tmpl.Funcs(template.FuncMap{"toJSON": promptTrimLevels[level].toJSON})
+		buf.Reset()
+		guard.reset()
+		if err := tmpl.ExecuteTemplate(&buf, tname, data); err != nil {
+			return renderedPrompt{}, fmt.Errorf("executing template %s from %s: %w", tname, source, err)
+		}
+		if s.PromptBudget == 0 || buf.Len() <= s.PromptBudget {
+			break
+		}
+		if level == 0 {
+			s.Log.Warn(ctx, "Prompt %s from %s is %d bytes, over the budget of %d; trimming payloads.", tname, source, buf.Len(), s.PromptBudget)
+		}
+	}
+	if s.PromptBudget > 0 && buf.Len() > s.PromptBudget {
+		s.Log.Warn(ctx, "Prompt %s from %s is still %d bytes after trimming, over the budget of %d.", tname, source, buf.Len(), s.PromptBudget)
 	}
-	return buf.String(), nil
+
+	guardrail := guard.guardrail()
+	if len(guardrail.Findings) > 0 {
+		s.Log.Warn(ctx, "Prompt %s for %s/%s has %d suspicious pattern(s) in untrusted content (policy %s, decision %s).", tname, owner, repo, len(guardrail.Findings), policy, guardrail.Decision)
+	}
+
+	r := promptRender{Time: time.Now(), Template: tname, Source: source, Bytes: buf.Len(), TrimLevel: level}
+	if owner != "" {
+		r.Repo = owner + "/" + repo
+	}
+	s.recordPromptRender(r)
+	return renderedPrompt{text: buf.String(), guardrail: guardrail}, nil
 }
 
 type promptTemplate interface {
@@ -38,73 +95,247 @@ type promptTemplate interface {
 	Data(context.Context) (any, error)
 }
 
-type promptReleasePublished struct {
-	dependent string
-	branch    string
-	event     *github.ReleaseEvent
+type promptRelease struct {
+	dependent           string
+	dependentEcosystems []ecosystemInfo
+	branch              string
+	upgrades            []releaseUpgrade
+	ecosystemGuides     []string            // Rendered ecosystem-specific instructions.
+	existingPR          *github.PullRequest // An open upgrade pull request to update, if any.
+	events              []any               // The release or create events.
 }
 
-func (p *promptReleasePublished) Name(context.Context) string {
+func (p *promptRelease) Name(context.Context) string {
 	return "release_published"
 }
 
-func (p *promptReleasePublished) Data(context.Context) (any, error) {
-	js, err := eventJSON(p.event)
-	if err != nil {
-		return nil, err
-	}
+func (p *promptRelease) Data(context.Context) (any, error) {
 	return struct {
-		Dependent string
-		Branch    string
-		EventJSON string
-		Event     any
+		Dependent           string
+		DependentEcosystems []ecosystemInfo
+		Branch              string
+		Upgrades            []releaseUpgrade
+		EcosystemGuides     []string
+		ExistingPRURL       string
+		ExistingPRTitle     string
+		ExistingBranch      string
+		Events              []any
 	}{
-		Dependent: p.dependent,
-		Branch:    p.branch,
-		EventJSON: js,
-		Event:     p.event,
+		Dependent:           p.dependent,
+		DependentEcosystems: p.dependentEcosystems,
+		Branch:              p.branch,
+		Upgrades:            p.upgrades,
+		EcosystemGuides:     p.ecosystemGuides,
+		ExistingPRURL:       p.existingPR.GetHTMLURL(),
+		ExistingPRTitle:     p.existingPR.GetTitle(),
+		ExistingBranch:      p.existingPR.GetHead().GetRef(),
+		Events:              p.events,
 	}, nil
 }
 
-type promptIssueCommentCreatedPopulatePR struct {
+// promptEcosystem renders the upgrade instructions for one ecosystem, which
+// are included in the release prompt.
+type promptEcosystem struct {
+	ecosystem ecosystem
+	upgrades  []releaseUpgrade
+}
+
+func (p *promptEcosystem) Name(context.Context) string {
+	return p.ecosystem.templateName()
+}
+
+func (p *promptEcosystem) Data(context.Context) (any, error) {
+	return struct {
+		Ecosystem string
+		Upgrades  []releaseUpgrade
+	}{
+		Ecosystem: string(p.ecosystem),
+		Upgrades:  p.upgrades,
+	}, nil
+}
+
+type promptPopulatePR struct {
 	projectID        string
 	region           string
 	commit           string
 	testOutputBucket string
 	goRepository     string
-	event            *github.IssueCommentEvent
+	pullRequest      *github.PullRequest
 }
 
-func (p *promptIssueCommentCreatedPopulatePR) Name(context.Context) string {
+func (p *promptPopulatePR) Name(context.Context) string {
 	return "issue_comment_created_populate_pr"
 }
 
-func (p *promptIssueCommentCreatedPopulatePR) Data(context.Context) (any, error) {
-	js, err := eventJSON(p.event.GetIssue())
-	if err != nil {
-		return nil, err
-	}
+func (p *promptPopulatePR) Data(context.Context) (any, error) {
 	return struct {
 		ProjectID        string
 		Region           string
 		Commit           string
 		TestOutputBucket string
 		GoRepository     string
-		PullRequestJSON  string
+		PullRequest      *github.PullRequest
 	}{
 		ProjectID:        p.projectID,
 		Region:           p.region,
 		Commit:           p.commit,
 		TestOutputBucket: p.testOutputBucket,
 		GoRepository:     p.goRepository,
-		PullRequestJSON:  js,
+		PullRequest:      p.pullRequest,
 	}, nil
 }
 
-func eventJSON(event any) (string, error) {
-	js, err := json.MarshalIndent(event, "", "  ")
-	if err != nil {
-		return "", fmt.Errorf("marshalling JSON: %w", err)
+type promptPush struct {
+	task         pushTask
+	branch       string
+	changedFiles []string
+	matchedFiles []string
+	event        *github.PushEvent
+}
+
+func (p *promptPush) Name(context.Context) string {
+	return "push"
+}
+
+func (p *promptPush) Data(context.Context) (any, error) {
+	return struct {
+		Task         string
+		Instructions string
+		Branch       string
+		ChangedFiles []string
+		MatchedFiles []string
+		Event        any
+	}{
+		Task:         p.task.Name,
+		Instructions: p.task.Instructions,
+		Branch:       p.branch,
+		ChangedFiles: p.changedFiles,
+		MatchedFiles: p.matchedFiles,
+		Event:        p.event,
+	}, nil
+}
+
+type promptImplementIssue struct {
+	serviceName string
+	repo        *github.Repository
+	issue       *github.Issue
+}
+
+func (p *promptImplementIssue) Name(context.Context) string {
+	return "issue_implement"
+}
+
+func (p *promptImplementIssue) Data(context.Context) (any, error) {
+	return struct {
+		ServiceName   string
+		Repository    string
+		DefaultBranch string
+		IssueNumber   int
+		IssueURL      string
+		Issue         *github.Issue
+	}{
+		ServiceName:   p.serviceName,
+		Repository:    p.repo.GetHTMLURL(),
+		DefaultBranch: p.repo.GetDefaultBranch(),
+		IssueNumber:   p.issue.GetNumber(),
+		IssueURL:      p.issue.GetHTMLURL(),
+		Issue:         p.issue,
+	}, nil
+}
+
+type promptReviewFix struct {
+	pullRequest *github.PullRequest
+	reviewBody  string
+	comments    []*github.PullRequestComment
+}
+
+// reviewComment is the part of a review comment that the agent needs.
+type reviewComment struct {
+	ID        int64
+	URL       string
+	Path      string
+	StartLine int
+	Line      int
+	Outdated  bool
+	DiffHunk  string
+	Body      string
+}
+
+func (p *promptReviewFix) Name(context.Context) string {
+	return "pull_request_review_fix"
+}
+
+func (p *promptReviewFix) Data(context.Context) (any, error) {
+	var comments []reviewComment
+	for _, c := range p.comments {
+		rc := reviewComment{
+			ID:        c.GetID(),
+			URL:       c.GetHTMLURL(),
+			Path:      c.GetPath(),
+			StartLine: c.GetStartLine(),
+			Line:      c.GetLine(),
+			DiffHunk:  c.GetDiffHunk(),
+			Body:      c.GetBody(),
+		}
+		if c.Line == nil { // The comment no longer applies to the latest diff.
+			rc.Outdated = true
+			rc.StartLine, rc.Line = c.GetOriginalStartLine(), c.GetOriginalLine()
+		}
+		if rc.StartLine == 0 {
+			rc.StartLine = rc.Line
+		}
+		comments = append(comments, rc)
 	}
-	return string(js), nil
+	return struct {
+		PullRequestURL string
+		Number         int
+		HeadRef        string
+		ReviewBody     string
+		Comments       []reviewComment
+		PullRequest    *github.PullRequest
+	}{
+		PullRequestURL: p.pullRequest.GetHTMLURL(),
+		Number:         p.pullRequest.GetNumber(),
+		HeadRef:        p.pullRequest.GetHead().GetRef(),
+		ReviewBody:     p.reviewBody,
+		Comments:       comments,
+		PullRequest:    p.pullRequest,
+	}, nil
+}
+
+type promptCITriage struct {
+	pullRequest *github.PullRequest
+	run         ciRun
+	failures    []ciFailure
+	pushFix     bool
+}
+
+func (p *promptCITriage) Name(context.Context) string {
+	return "ci_triage"
+}
+
+func (p *promptCITriage) Data(context.Context) (any, error) {
+	return struct {
+		PullRequestURL string
+		Number         int
+		HeadRef        string
+		HeadSHA        string
+		Kind           string
+		RunName        string
+		RunURL         string
+		Failures       []ciFailure
+		PushFix        bool
+		PullRequest    *github.PullRequest
+	}{
+		PullRequestURL: p.pullRequest.GetHTMLURL(),
+		Number:         p.pullRequest.GetNumber(),
+		HeadRef:        p.pullRequest.GetHead().GetRef(),
+		HeadSHA:        p.run.headSHA,
+		Kind:           p.run.kind,
+		RunName:        p.run.name,
+		RunURL:         p.run.url,
+		Failures:       p.failures,
+		PushFix:        p.pushFix,
+		PullRequest:    p.pullRequest,
+	}, nil
 }
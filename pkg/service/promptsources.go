@@ -0,0 +1,352 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// repoPromptsDir holds template overrides in a target repository.
+	repoPromptsDir = ".pillar/prompts"
+
+	promptSourceEmbedded = "embedded"
+	maxPromptFileSize    = 256 << 10
+	maxPromptRenders     = 100
+)
+
+// A promptSource supplies prompt template files that override the embedded
+// ones, keyed by file name (e.g. "push.tmpl"). Overrides are layered: the
+// embedded templates, then the GCS prefix, then the target repository.
+type promptSource interface {
+	String() string
+	load(ctx context.Context) (map[string]string, error)
+}
+
+// gcsPromptSource reads the *.tmpl objects directly under a gs://bucket/prefix
+// URL.
+type gcsPromptSource struct {
+	bucket, prefix string
+}
+
+func parseGCSPromptSource(url string) (*gcsPromptSource, error) {
+	rest, ok := strings.CutPrefix(url, "gs://")
+	if !ok {
+		return nil, fmt.Errorf("%q is not a gs:// URL", url)
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("%q has no bucket", url)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &gcsPromptSource{bucket: bucket, prefix: prefix}, nil
+}
+
+func (g *gcsPromptSource) String() string {
+	return "gs://" + g.bucket + "/" + g.prefix
+}
+
+func (g *gcsPromptSource) load(ctx context.Context) (map[string]string, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCS client: %w", err)
+	}
+	defer client.Close()
+
+	files := make(map[string]string)
+	bucket := client.Bucket(g.bucket)
+	it := bucket.Objects(ctx, &storage.Query{Prefix: g.prefix, Delimiter: "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: %w", g, err)
+		}
+		name := strings.TrimPrefix(attrs.Name, g.prefix)
+		if attrs.Name == "" || !strings.HasSuffix(name, ".tmpl") {
+			continue // A "directory" or another kind of file.
+		}
+		if attrs.Size > maxPromptFileSize {
+			return nil, fmt.Errorf("%s%s is larger than %d bytes", g, name, maxPromptFileSize)
+		}
+		r, err := bucket.Object(attrs.Name).NewReader(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s%s: %w", g, name, err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s%s: %w", g, name, err)
+		}
+		files[name] = string(b)
+	}
+	return files, nil
+}
+
+// repoPromptSource reads the *.tmpl files in repoPromptsDir on the default
+// branch of a repository.
+type repoPromptSource struct {
+	s              *Service
+	installationID int64
+	owner, repo    string
+}
+
+func (r *repoPromptSource) String() string {
+	return r.owner + "/" + r.repo + "/" + repoPromptsDir
+}
+
+func (r *repoPromptSource) load(ctx context.Context) (map[string]string, error) {
+	ghClient, err := r.s.githubClient(ctx, r.installationID)
+	if err != nil {
+		return nil, fmt.Errorf("creating github client: %v", err)
+	}
+
+	_, dir, resp, err := ghClient.Repositories.GetContents(ctx, r.owner, r.repo, repoPromptsDir, nil)
+	switch {
+	case statusCode(resp) == http.StatusNotFound:
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("listing %s: %w", r, err)
+	}
+
+	files := make(map[string]string)
+	for _, e := range dir {
+		if e.GetType() != "file" || !strings.HasSuffix(e.GetName(), ".tmpl") {
+			continue
+		}
+		if e.GetSize() > maxPromptFileSize {
+			return nil, fmt.Errorf("%s/%s is larger than %d bytes", r, e.GetName(), maxPromptFileSize)
+		}
+		file, _, _, err := ghClient.Repositories.GetContents(ctx, r.owner, r.repo, path.Join(repoPromptsDir, e.GetName()), nil)
+		if err != nil {
+			return nil, fmt.Errorf("getting %s/%s: %w", r, e.GetName(), err)
+		}
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s/%s: %w", r, e.GetName(), err)
+		}
+		files[e.GetName()] = content
+	}
+	return files, nil
+}
+
+// promptLayer is the cached, validated result of loading a promptSource.
+type promptLayer struct {
+	Source   string            `json:"source"`
+	Files    []string          `json:"files"`
+	Invalid  map[string]string `json:"invalid,omitempty"` // File name to error.
+	Error    string            `json:"error,omitempty"`   // Set if the last load failed.
+	LoadedAt time.Time         `json:"loadedAt"`
+
+	files  map[string]string
+	expiry time.Time
+}
+
+// promptRender records which source served the template of a render.
+type promptRender struct {
+	Time      time.Time `json:"time"`
+	Template  string    `json:"template"`
+	Source    string    `json:"source"`
+	Repo      string    `json:"repo,omitempty"`
+	Bytes     int       `json:"bytes"`
+	TrimLevel int       `json:"trimLevel"` // The promptTrimLevels index applied.
+}
+
+type promptSources struct {
+	mu      sync.Mutex
+	gcs     *promptLayer
+	repos   map[string]*promptLayer // Keyed by "<owner>/<repo>".
+	renders []promptRender          // Most recent last.
+}
+
+func newPromptSources() *promptSources {
+	return &promptSources{repos: make(map[string]*promptLayer)}
+}
+
+// promptTemplates returns the embedded templates with the overrides for the
+// target repository applied, and the source that serves tname.
+func (s *Service) promptTemplates(ctx context.Context, installationID int64, owner, repo, tname string) (*template.Template, string, error) {
+	var layers []*promptLayer
+	if s.PromptTemplatesURL != "" {
+		layers = append(layers, s.gcsPromptLayer(ctx))
+	}
+	if s.EnableRepoPrompts && owner != "" {
+		layers = append(layers, s.repoPromptLayer(ctx, installationID, owner, repo))
+	}
+
+	t, err := s.prompts.Clone()
+	if err != nil {
+		return nil, "", fmt.Errorf("cloning templates: %v", err)
+	}
+	source := promptSourceEmbedded
+	for _, l := range layers {
+		for name, content := range l.files {
+			if _, err := t.New(name).Parse(content); err != nil {
+				// Validated when loaded, so this is not expected.
+				return nil, "", fmt.Errorf("parsing %s from %s: %v", name, l.Source, err)
+			}
+		}
+		if _, ok := l.files[tname]; ok {
+			source = l.Source
+		}
+	}
+	return t, source, nil
+}
+
+func (s *Service) gcsPromptLayer(ctx context.Context) *promptLayer {
+	s.promptSources.mu.Lock()
+	l := s.promptSources.gcs
+	s.promptSources.mu.Unlock()
+	if l != nil && time.Now().Before(l.expiry) {
+		return l
+	}
+
+	src, err := parseGCSPromptSource(s.PromptTemplatesURL)
+	if err != nil {
+		// Checked by Config.validate.
+		return &promptLayer{Source: s.PromptTemplatesURL, Error: err.Error()}
+	}
+	l = s.loadPromptLayer(ctx, src, l)
+
+	s.promptSources.mu.Lock()
+	defer s.promptSources.mu.Unlock()
+	s.promptSources.gcs = l
+	return l
+}
+
+func (s *Service) repoPromptLayer(ctx context.Context, installationID int64, owner, repo string) *promptLayer {
+	key := owner + "/" + repo
+	s.promptSources.mu.Lock()
+	l := s.promptSources.repos[key]
+	s.promptSources.mu.Unlock()
+	if l != nil && time.Now().Before(l.expiry) {
+		return l
+	}
+
+	l = s.loadPromptLayer(ctx, &repoPromptSource{s: s, installationID: installationID, owner: owner, repo: repo}, l)
+
+	s.promptSources.mu.Lock()
+	defer s.promptSources.mu.Unlock()
+	s.promptSources.repos[key] = l
+	return l
+}
+
+// loadPromptLayer loads and validates the templates of src. If loading fails,
+// the previous layer (if any) keeps being used until the next reload.
+func (s *Service) loadPromptLayer(ctx context.Context, src promptSource, prev *promptLayer) *promptLayer {
+	now := time.Now()
+	files, err := src.load(ctx)
+	if err != nil {
+		s.Log.Warn(ctx, "Failed to load prompt templates from %s, continuing: %v", src, err)
+		l := &promptLayer{Source: src.String(), LoadedAt: now}
+		if prev != nil {
+			*l = *prev
+		}
+		l.Error = err.Error()
+		l.expiry = now.Add(s.PromptReloadInterval)
+		return l
+	}
+
+	valid, invalid := validatePromptFiles(s.prompts, files)
+	for name, err := range invalid {
+		s.Log.Warn(ctx, "Ignoring prompt template %s from %s: %s", name, src, err)
+	}
+	return &promptLayer{
+		Source:   src.String(),
+		Files:    slices.Sorted(maps.Keys(valid)),
+		Invalid:  invalid,
+		LoadedAt: now,
+		files:    valid,
+		expiry:   now.Add(s.PromptReloadInterval),
+	}
+}
+
+// validatePromptFiles splits files into those that can override a template in
+// base and the errors of those that cannot. A file may only define the
+// template it is named after, so that it cannot replace other templates or
+// partials.
+func validatePromptFiles(base *template.Template, files map[string]string) (map[string]string, map[string]string) {
+	valid := make(map[string]string)
+	var invalid map[string]string
+	reject := func(name string, err error) {
+		if invalid == nil {
+			invalid = make(map[string]string)
+		}
+		invalid[name] = err.Error()
+	}
+	for name, content := range files {
+		if base.Lookup(name) == nil {
+			reject(name, errors.New("no embedded template has this name"))
+			continue
+		}
+		own, err := template.New(name).Funcs(promptFuncs).Parse(content)
+		if err != nil {
+			reject(name, err)
+			continue
+		}
+		var others []string
+		for _, d := range own.Templates() {
+			if d.Name() != name {
+				others = append(others, d.Name())
+			}
+		}
+		if len(others) > 0 {
+			slices.Sort(others)
+			reject(name, fmt.Errorf("defines templates other than %s: %s", name, strings.Join(others, ", ")))
+			continue
+		}
+		t, err := base.Clone()
+		if err != nil {
+			reject(name, err)
+			continue
+		}
+		if _, err := t.New(name).Parse(content); err != nil {
+			reject(name, err)
+			continue
+		}
+		valid[name] = content
+	}
+	return valid, invalid
+}
+
+// recordPromptRender keeps the source of the most recent renders.
+func (s *Service) recordPromptRender(r promptRender) {
+	s.promptSources.mu.Lock()
+	defer s.promptSources.mu.Unlock()
+	s.promptSources.renders = append(s.promptSources.renders, r)
+	if n := len(s.promptSources.renders) - maxPromptRenders; n > 0 {
+		s.promptSources.renders = slices.Delete(s.promptSources.renders, 0, n)
+	}
+}
+
+// forgetRepoPrompts drops the cached template overrides of "<owner>/<repo>",
+// so the next render reloads them.
+func (s *Service) forgetRepoPrompts(fullName string) {
+	s.promptSources.mu.Lock()
+	defer s.promptSources.mu.Unlock()
+	delete(s.promptSources.repos, fullName)
+}
+
+// reloadPrompts drops all cached template overrides.
+func (s *Service) reloadPrompts() {
+	s.promptSources.mu.Lock()
+	defer s.promptSources.mu.Unlock()
+	s.promptSources.gcs = nil
+	clear(s.promptSources.repos)
+}
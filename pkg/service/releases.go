@@ -0,0 +1,355 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v75/github"
+	"github.com/google/uuid"
+	"github.com/squee1945/pillar-service/pkg/jobs"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	// Tags are only listed this far back when looking for the previous version.
+	maxTagPages = 10
+
+	// releaseBatchKeyPrefix starts the debouncer keys of release batches.
+	releaseBatchKeyPrefix = "release/"
+)
+
+// releaseVersion is a release tag parsed as a semantic version. Tags of Go
+// modules in subdirectories (e.g., "sub/v1.2.3") are supported.
+type releaseVersion struct {
+	tag     string // E.g., "sub/v1.2.3".
+	version string // The tag's last element, as written; e.g., "v1.2.3".
+	semver  string // The canonical semver, with a "v" prefix; empty for rawReleaseVersion.
+}
+
+func parseReleaseVersion(tag string) (releaseVersion, bool) {
+	tag = strings.TrimPrefix(tag, "refs/tags/")
+	version := path.Base(tag)
+	sv := version
+	if !strings.HasPrefix(sv, "v") {
+		sv = "v" + sv
+	}
+	if !semver.IsValid(sv) {
+		return releaseVersion{}, false
+	}
+	return releaseVersion{tag: tag, version: version, semver: semver.Canonical(sv)}, true
+}
+
+// rawReleaseVersion is the version of a tag that is not a semantic version,
+// such as "2024-06-01".
+func rawReleaseVersion(tag string) releaseVersion {
+	tag = strings.TrimPrefix(tag, "refs/tags/")
+	return releaseVersion{tag: tag, version: path.Base(tag)}
+}
+
+func (v releaseVersion) prerelease() bool {
+	return semver.Prerelease(v.semver) != ""
+}
+
+// previousVersion finds the highest version tagged in the repository that is
+// lower than v, in the same module directory. Pre-releases are skipped unless
+// includePrereleases is set. It returns false if there is none.
+func (s *Service) previousVersion(ctx context.Context, installationID int64, owner, repo string, v releaseVersion, includePrereleases bool) (releaseVersion, bool, error) {
+	ghClient, err := s.githubClient(ctx, installationID)
+	if err != nil {
+		return releaseVersion{}, false, fmt.Errorf("creating github client: %v", err)
+	}
+
+	var names []string
+	opts := &github.ListOptions{PerPage: 100}
+	for range maxTagPages {
+		tags, resp, err := ghClient.Repositories.ListTags(ctx, owner, repo, opts)
+		if err != nil {
+			return releaseVersion{}, false, fmt.Errorf("listing tags: %w", err)
+		}
+		for _, t := range tags {
+			names = append(names, t.GetName())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	prev, found := highestVersionBelow(names, v, includePrereleases)
+	return prev, found, nil
+}
+
+// highestVersionBelow returns the highest version among tags that is lower
+// than v, in the same module directory, skipping pre-releases unless
+// includePrereleases is set.
+func highestVersionBelow(tags []string, v releaseVersion, includePrereleases bool) (releaseVersion, bool) {
+	var prev releaseVersion
+	found := false
+	for _, t := range tags {
+		tv, ok := parseReleaseVersion(t)
+		if !ok || path.Dir(tv.tag) != path.Dir(v.tag) || (tv.prerelease() && !includePrereleases) {
+			continue
+		}
+		if semver.Compare(tv.semver, v.semver) < 0 && (!found || semver.Compare(tv.semver, prev.semver) > 0) {
+			prev, found = tv, true
+		}
+	}
+	return prev, found
+}
+
+// releaseUpgrade is an upgrade of one package, for the prompt.
+type releaseUpgrade struct {
+	Package    string // The upstream repository URL.
+	Ecosystem  ecosystem
+	Name       string // The package name in its ecosystem, if known.
+	Tag        string
+	NewVersion string
+	OldVersion string // Empty if there is no earlier version.
+	Prerelease bool
+	ReleaseURL string
+
+	semver string
+}
+
+// Version returns the new version as the ecosystem spells it; only Go uses a
+// "v" prefix.
+func (u releaseUpgrade) Version() string {
+	if u.Ecosystem == ecosystemGo {
+		return u.NewVersion
+	}
+	return strings.TrimPrefix(u.NewVersion, "v")
+}
+
+// releaseBatch collects the upgrades for one dependent during the aggregation
+// window, so that they are applied by a single runner.
+type releaseBatch struct {
+	installationID int64
+	forker         *github.User
+	dependent      repository
+	trigger        jobTrigger // The most recent.
+	deliveryID     string     // The most recent.
+	upgrades       []releaseUpgrade
+	events         []any
+}
+
+// add records u, replacing an older version of the same package.
+func (b *releaseBatch) add(u releaseUpgrade, event any) {
+	b.events = append(b.events, event)
+	for i, prev := range b.upgrades {
+		if prev.Package != u.Package || path.Dir(prev.Tag) != path.Dir(u.Tag) {
+			continue
+		}
+		// Versions that cannot be compared are taken in the order released.
+		if u.semver == "" || prev.semver == "" || semver.Compare(u.semver, prev.semver) > 0 {
+			u.OldVersion = prev.OldVersion
+			b.upgrades[i] = u
+		}
+		return
+	}
+	b.upgrades = append(b.upgrades, u)
+}
+
+type releaseBatches struct {
+	mu      sync.Mutex
+	pending map[string]*releaseBatch
+}
+
+func newReleaseBatches() *releaseBatches {
+	return &releaseBatches{pending: make(map[string]*releaseBatch)}
+}
+
+// queueUpgrade adds an upgrade to the dependent's batch, and (re)starts its
+// aggregation window. When the window passes without further releases, a
+// single runner applies every upgrade in the batch.
+func (s *Service) queueUpgrade(ctx context.Context, trigger jobTrigger, installationID int64, forker *github.User, dep repository, u releaseUpgrade, event any) {
+	key := fmt.Sprintf("%s%d/%s/%s/%s", releaseBatchKeyPrefix, installationID, forker.GetLogin(), dep.owner, dep.repo)
+
+	b := s.releaseBatches
+	b.mu.Lock()
+	batch, ok := b.pending[key]
+	if !ok {
+		batch = &releaseBatch{installationID: installationID, forker: forker, dependent: dep}
+		b.pending[key] = batch
+	}
+	batch.trigger = trigger
+	batch.deliveryID = deliveryIDFromContext(ctx)
+	batch.add(u, event)
+	n := len(batch.upgrades)
+	b.mu.Unlock()
+
+	runCtx := context.WithoutCancel(ctx)
+	s.debouncer.schedule(key, s.ReleaseBatchWindow, func() {
+		b.mu.Lock()
+		batch := b.pending[key]
+		delete(b.pending, key)
+		b.mu.Unlock()
+		if batch == nil {
+			return // Flushed on shutdown.
+		}
+		s.runReleaseBatch(runCtx, batch)
+	})
+	s.Log.Info(ctx, "Queued upgrade of %s to %s for %s/%s (%d in batch, window %s)", u.Package, u.NewVersion, dep.owner, dep.repo, n, s.ReleaseBatchWindow)
+}
+
+// flushReleaseBatches runs the pending release batches without waiting for
+// their aggregation windows to pass, e.g. when the instance is shutting down.
+func (s *Service) flushReleaseBatches(ctx context.Context) {
+	s.debouncer.cancelPrefix(releaseBatchKeyPrefix)
+
+	b := s.releaseBatches
+	b.mu.Lock()
+	batches := slices.Collect(maps.Values(b.pending))
+	clear(b.pending)
+	b.mu.Unlock()
+	if len(batches) == 0 {
+		return
+	}
+
+	s.Log.Info(ctx, "Flushing %d pending release batch(es)", len(batches))
+	var wg sync.WaitGroup
+	for _, batch := range batches {
+		wg.Go(func() {
+			s.runReleaseBatch(withDeliveryID(ctx, batch.deliveryID), batch)
+		})
+	}
+	wg.Wait()
+}
+
+// runReleaseBatch upgrades the dependent of batch. Failures are recorded on a
+// job, as there is no webhook request left to report them to.
+func (s *Service) runReleaseBatch(ctx context.Context, batch *releaseBatch) {
+	if err := s.upgradeDependent(ctx, batch); err != nil {
+		s.Log.Error(ctx, "Upgrading %s/%s failed: %v", batch.dependent.owner, batch.dependent.repo, err)
+	}
+}
+
+// upgradeDependent launches a runner that applies a batch of upgrades to a
+// fork of the dependent, updating an open upgrade pull request if there is
+// one.
+func (s *Service) upgradeDependent(ctx context.Context, batch *releaseBatch) error {
+	fork, prompt, opts, err := s.prepareUpgrade(ctx, batch)
+	if err != nil {
+		s.recordBatchFailure(ctx, batch, err)
+		return err
+	}
+	// run records its own failures on the job.
+	return s.run(ctx, batch.trigger, batch.installationID, fork, prompt, opts...)
+}
+
+// prepareUpgrade forks the dependent of batch and renders the prompt that
+// upgrades it.
+func (s *Service) prepareUpgrade(ctx context.Context, batch *releaseBatch) (*github.Repository, renderedPrompt, []configOption, error) {
+	dep := batch.dependent
+	fork, err := s.fork(ctx, batch.installationID, dep.owner, dep.repo, batch.forker)
+	if err != nil {
+		return nil, renderedPrompt{}, nil, fmt.Errorf("forking %s/%s: %v", dep.owner, dep.repo, err)
+	}
+
+	existing, err := s.openUpgradePR(ctx, batch.installationID, dep, fork)
+	if err != nil {
+		s.Log.Warn(ctx, "Failed to look for an open upgrade pull request on %s/%s, continuing: %v", dep.owner, dep.repo, err)
+	}
+
+	var dependentEcosystems []ecosystemInfo
+	if ghClient, err := s.githubClient(ctx, batch.installationID); err != nil {
+		s.Log.Warn(ctx, "Failed to create github client, continuing: %v", err)
+	} else if dependentEcosystems, err = s.detectEcosystems(ctx, ghClient, dep.owner, dep.repo, "", ""); err != nil {
+		s.Log.Warn(ctx, "Failed to detect ecosystems of %s/%s, continuing: %v", dep.owner, dep.repo, err)
+	}
+	guides, err := s.ecosystemGuides(ctx, batch.installationID, dep, batch.upgrades)
+	if err != nil {
+		return nil, renderedPrompt{}, nil, err
+	}
+
+	prompt, err := s.renderPrompt(ctx, batch.installationID, dep.owner, dep.repo, &promptRelease{
+		dependent:           fmt.Sprintf("https://github.com/%s/%s", dep.owner, dep.repo),
+		dependentEcosystems: dependentEcosystems,
+		upgrades:            batch.upgrades,
+		ecosystemGuides:     guides,
+		existingPR:          existing,
+		events:              batch.events,
+	})
+	if err != nil {
+		return nil, renderedPrompt{}, nil, fmt.Errorf("rendering prompt: %v", err)
+	}
+
+	var opts []configOption
+	if existing != nil {
+		opts = append(opts, withBranch(existing.GetHead().GetRef()))
+		s.Log.Info(ctx, "Updating %s with %d upgrade(s)", existing.GetHTMLURL(), len(batch.upgrades))
+	} else {
+		s.Log.Info(ctx, "Upgrading %s/%s with %d upgrade(s)", dep.owner, dep.repo, len(batch.upgrades))
+	}
+	return fork, prompt, opts, nil
+}
+
+// recordBatchFailure records a failed job for a batch that failed before its
+// runner could be launched.
+func (s *Service) recordBatchFailure(ctx context.Context, batch *releaseBatch, err error) {
+	uid, uerr := uuid.NewRandom()
+	if uerr != nil {
+		s.Log.Warn(ctx, "Failed to generate job ID, continuing: %v", uerr)
+		return
+	}
+	s.recordFailedJob(ctx, &jobs.Job{
+		ID:             uid.String(),
+		Trigger:        batch.trigger.name,
+		TriggerURL:     batch.trigger.url,
+		DeliveryID:     batch.deliveryID,
+		InstallationID: batch.installationID,
+		Owner:          batch.dependent.owner,
+		Repo:           batch.dependent.repo,
+	}, err)
+}
+
+// openUpgradePR returns the most recent open pull request on dep from a
+// runner branch of fork, or nil if there is none.
+func (s *Service) openUpgradePR(ctx context.Context, installationID int64, dep repository, fork *github.Repository) (*github.PullRequest, error) {
+	ghClient, err := s.githubClient(ctx, installationID)
+	if err != nil {
+		return nil, fmt.Errorf("creating github client: %v", err)
+	}
+	prs, _, err := ghClient.PullRequests.List(ctx, dep.owner, dep.repo, &github.PullRequestListOptions{
+		State:       "open",
+		Sort:        "created",
+		Direction:   "desc",
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing pull requests: %w", err)
+	}
+	for _, pr := range prs {
+		if pr.GetHead().GetRepo().GetID() == fork.GetID() && strings.HasPrefix(pr.GetHead().GetRef(), s.ServiceName+"-") {
+			return pr, nil
+		}
+	}
+	return nil, nil
+}
+
+// ecosystemGuides renders the ecosystem-specific upgrade instructions for the
+// ecosystems of upgrades to dep.
+func (s *Service) ecosystemGuides(ctx context.Context, installationID int64, dep repository, upgrades []releaseUpgrade) ([]string, error) {
+	var ecosystems []ecosystem
+	for _, u := range upgrades {
+		if u.Ecosystem != "" && !slices.Contains(ecosystems, u.Ecosystem) {
+			ecosystems = append(ecosystems, u.Ecosystem)
+		}
+	}
+
+	var guides []string
+	for _, e := range ecosystems {
+		guide, err := s.renderPrompt(ctx, installationID, dep.owner, dep.repo, &promptEcosystem{
+			ecosystem: e,
+			upgrades:  slices.DeleteFunc(slices.Clone(upgrades), func(u releaseUpgrade) bool { return u.Ecosystem != e }),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("rendering %s instructions: %v", e, err)
+		}
+		guides = append(guides, guide.text)
+	}
+	return guides, nil
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestReleaseBatchAdd(t *testing.T) {
+	upgrade := func(pkg, tag, old string) releaseUpgrade {
+		v, ok := parseReleaseVersion(tag)
+		if !ok {
+			v = rawReleaseVersion(tag)
+		}
+		return releaseUpgrade{Package: pkg, Tag: v.tag, NewVersion: v.version, OldVersion: old, semver: v.semver}
+	}
+	type want struct{ pkg, tag, old string }
+
+	tests := []struct {
+		name string
+		adds []releaseUpgrade
+		want []want
+	}{
+		{
+			name: "one release",
+			adds: []releaseUpgrade{upgrade("lib", "v1.1.0", "v1.0.0")},
+			want: []want{{"lib", "v1.1.0", "v1.0.0"}},
+		},
+		{
+			name: "newer release replaces, keeping the oldest previous version",
+			adds: []releaseUpgrade{upgrade("lib", "v1.1.0", "v1.0.0"), upgrade("lib", "v1.2.0", "v1.1.0")},
+			want: []want{{"lib", "v1.2.0", "v1.0.0"}},
+		},
+		{
+			name: "older release is ignored",
+			adds: []releaseUpgrade{upgrade("lib", "v1.2.0", "v1.1.0"), upgrade("lib", "v1.1.1", "v1.1.0")},
+			want: []want{{"lib", "v1.2.0", "v1.1.0"}},
+		},
+		{
+			name: "other packages are added",
+			adds: []releaseUpgrade{upgrade("lib", "v1.1.0", "v1.0.0"), upgrade("ui", "v3.0.0", "v2.9.0")},
+			want: []want{{"lib", "v1.1.0", "v1.0.0"}, {"ui", "v3.0.0", "v2.9.0"}},
+		},
+		{
+			name: "modules in other directories are added",
+			adds: []releaseUpgrade{upgrade("lib", "v1.1.0", "v1.0.0"), upgrade("lib", "sub/v0.2.0", "sub/v0.1.0")},
+			want: []want{{"lib", "v1.1.0", "v1.0.0"}, {"lib", "sub/v0.2.0", "sub/v0.1.0"}},
+		},
+		{
+			name: "non-semver releases are taken in order",
+			adds: []releaseUpgrade{upgrade("lib", "2024-06-01", ""), upgrade("lib", "2024-05-01", "")},
+			want: []want{{"lib", "2024-05-01", ""}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var b releaseBatch
+			for i, u := range tc.adds {
+				b.add(u, i)
+			}
+			var got []want
+			for _, u := range b.upgrades {
+				got = append(got, want{u.Package, u.Tag, u.OldVersion})
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("upgrades = %v, want %v", got, tc.want)
+			}
+			if len(b.events) != len(tc.adds) {
+				t.Errorf("len(events) = %d, want %d", len(b.events), len(tc.adds))
+			}
+		})
+	}
+}
+
+func TestParseReleaseVersion(t *testing.T) {
+	tests := []struct {
+		tag                              string
+		wantOK                           bool
+		wantTag, wantVersion, wantSemver string
+	}{
+		{tag: "v1.2.3", wantOK: true, wantTag: "v1.2.3", wantVersion: "v1.2.3", wantSemver: "v1.2.3"},
+		{tag: "1.2.3", wantOK: true, wantTag: "1.2.3", wantVersion: "1.2.3", wantSemver: "v1.2.3"},
+		{tag: "v1.2", wantOK: true, wantTag: "v1.2", wantVersion: "v1.2", wantSemver: "v1.2.0"},
+		{tag: "refs/tags/v2.0.0-rc.1", wantOK: true, wantTag: "v2.0.0-rc.1", wantVersion: "v2.0.0-rc.1", wantSemver: "v2.0.0-rc.1"},
+		{tag: "sub/mod/v0.3.0", wantOK: true, wantTag: "sub/mod/v0.3.0", wantVersion: "v0.3.0", wantSemver: "v0.3.0"},
+		{tag: "2024-06-01"},
+		{tag: "latest"},
+		{tag: ""},
+	}
+	for _, tc := range tests {
+		got, ok := parseReleaseVersion(tc.tag)
+		if ok != tc.wantOK {
+			t.Errorf("parseReleaseVersion(%q) ok = %t, want %t", tc.tag, ok, tc.wantOK)
+			continue
+		}
+		if got.tag != tc.wantTag || got.version != tc.wantVersion || got.semver != tc.wantSemver {
+			t.Errorf("parseReleaseVersion(%q) = {%q %q %q}, want {%q %q %q}", tc.tag, got.tag, got.version, got.semver, tc.wantTag, tc.wantVersion, tc.wantSemver)
+		}
+	}
+}
+
+func TestHighestVersionBelow(t *testing.T) {
+	tags := []string{"v1.0.0", "v1.1.0", "v1.2.0-rc.1", "v1.2.0", "v2.0.0", "sub/v0.1.0", "sub/v0.2.0", "nightly", "1.1.5"}
+	tests := []struct {
+		name        string
+		v           string
+		prereleases bool
+		want        string // The tag, or "" if none.
+	}{
+		{name: "highest below", v: "v2.0.0", want: "v1.2.0"},
+		{name: "skips pre-releases", v: "v1.2.0", want: "1.1.5"},
+		{name: "includes pre-releases", v: "v1.2.0", prereleases: true, want: "v1.2.0-rc.1"},
+		{name: "pre-release below its release", v: "v1.2.0-rc.2", prereleases: true, want: "v1.2.0-rc.1"},
+		{name: "module directory", v: "sub/v0.3.0", want: "sub/v0.2.0"},
+		{name: "first version", v: "v1.0.0"},
+		{name: "first module version", v: "sub/v0.1.0"},
+		{name: "new directory", v: "other/v1.0.0"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v, ok := parseReleaseVersion(tc.v)
+			if !ok {
+				t.Fatalf("parseReleaseVersion(%q) failed", tc.v)
+			}
+			got, found := highestVersionBelow(tags, v, tc.prereleases)
+			if found != (tc.want != "") || got.tag != tc.want {
+				t.Errorf("highestVersionBelow(%s) = %q, %t; want %q", tc.v, got.tag, found, tc.want)
+			}
+		})
+	}
+}
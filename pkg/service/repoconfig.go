@@ -0,0 +1,308 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	repoConfigPath = ".pillar.yaml"
+	repoConfigTTL  = time.Minute
+
+	defaultPullRequestDebounce = 2 * time.Minute
+	defaultCITriageLogLines    = 100
+)
+
+// repoConfig is the per-repository configuration, read from .pillar.yaml at the
+// root of the repository's default branch. A missing file means defaults.
+type repoConfig struct {
+	PullRequests pullRequestsConfig `yaml:"pullRequests"`
+	Push         pushConfig         `yaml:"push"`
+	CITriage     ciTriageConfig     `yaml:"ciTriage"`
+	Releases     releasesConfig     `yaml:"releases"`
+	Commands     commandsConfig     `yaml:"commands"`
+	// PromptInjection sets how untrusted content (issue and pull request text,
+	// review comments, release notes, CI logs) is screened.
+	PromptInjection promptInjectionConfig `yaml:"promptInjection"`
+}
+
+// promptInjectionConfig sets what happens when untrusted content in a prompt
+// matches a known prompt injection pattern. Untrusted content is always fenced.
+type promptInjectionConfig struct {
+	// Policy is "flag" (the default) to run the job and record the findings,
+	// "refuse" to record the job without running it, or "off" to not scan.
+	Policy string `yaml:"policy"`
+}
+
+// commandsConfig controls who can start an agent with a "/<service> <command>"
+// comment. Repository owners, organization members and collaborators always
+// can.
+type commandsConfig struct {
+	// AllowedUsers are further GitHub logins that can run commands.
+	AllowedUsers []string `yaml:"allowedUsers"`
+}
+
+// pullRequestsConfig controls automatic populate-pr runs on pull_request
+// events. It is opt-in.
+type pullRequestsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Actions that trigger a run; defaults to opened, synchronize and
+	// ready_for_review.
+	Actions []string `yaml:"actions"`
+	// Draft pull requests are skipped unless IncludeDrafts is set.
+	IncludeDrafts bool `yaml:"includeDrafts"`
+	// Debounce delays a run so that a burst of pushes triggers a single run
+	// for the latest head commit.
+	Debounce time.Duration `yaml:"debounce"`
+}
+
+// ciTriageConfig controls diagnosis of failed workflow and check runs on pull
+// requests. It is opt-in.
+type ciTriageConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// PushFixes lets the agent push a fix to the pull request's branch rather
+	// than only commenting. Pull requests from forks are only commented on.
+	PushFixes bool `yaml:"pushFixes"`
+	// LogLines is how many lines from the end of each failed job's log are
+	// included in the prompt.
+	LogLines int `yaml:"logLines"`
+}
+
+// releasesConfig controls which releases of this repository upgrade its
+// dependents.
+type releasesConfig struct {
+	// Release actions that trigger an upgrade: published, prereleased,
+	// released and edited. Defaults to published.
+	Actions []string `yaml:"actions"`
+	// Pre-release versions (by flag or semver) are skipped unless
+	// IncludePrereleases is set.
+	IncludePrereleases bool `yaml:"includePrereleases"`
+	// Tags triggers on tag creation, for repositories that don't use GitHub
+	// Releases.
+	Tags bool `yaml:"tags"`
+	// Tags that are not semantic versions are upgraded to as written, without
+	// a previous version, unless SemverOnly is set.
+	SemverOnly bool `yaml:"semverOnly"`
+}
+
+var releaseActions = []string{"published", "prereleased", "released", "edited"}
+
+// pushConfig declares agent tasks run on pushes, e.g., regenerating docs or
+// updating a changelog.
+type pushConfig struct {
+	Tasks []pushTask `yaml:"tasks"`
+}
+
+// pushTask is run for a push to a matching branch that changes at least one
+// matching path.
+type pushTask struct {
+	Name string `yaml:"name"`
+	// Branch name globs; defaults to the repository's default branch.
+	Branches []string `yaml:"branches"`
+	// Path globs, where "**" matches any number of directories; defaults to
+	// all paths.
+	Paths []string `yaml:"paths"`
+	// Instructions are included in the prompt, describing what to do.
+	Instructions string `yaml:"instructions"`
+}
+
+func (t *pushTask) matchesBranch(branch, defaultBranch string) bool {
+	if len(t.Branches) == 0 {
+		return branch == defaultBranch
+	}
+	return slices.ContainsFunc(t.Branches, func(pattern string) bool {
+		ok, _ := path.Match(pattern, branch)
+		return ok
+	})
+}
+
+// matchingPaths returns the files that match the task's path globs.
+func (t *pushTask) matchingPaths(files []string) []string {
+	if len(t.Paths) == 0 {
+		return files
+	}
+	var matched []string
+	for _, f := range files {
+		if slices.ContainsFunc(t.Paths, func(pattern string) bool { return matchGlob(pattern, f) }) {
+			matched = append(matched, f)
+		}
+	}
+	return matched
+}
+
+func (t *pushTask) validate() error {
+	var errs []error
+	if t.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if strings.TrimSpace(t.Instructions) == "" {
+		errs = append(errs, errors.New("instructions are required"))
+	}
+	for _, p := range append(slices.Clone(t.Branches), t.Paths...) {
+		if _, err := path.Match(strings.ReplaceAll(p, "**", "*"), ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid glob %q", p))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// matchGlob matches a slash-separated name against a path.Match pattern in
+// which a "**" element matches zero or more elements.
+func matchGlob(pattern, name string) bool {
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := range len(name) + 1 {
+				if matchElems(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func defaultRepoConfig() *repoConfig {
+	return &repoConfig{
+		PullRequests: pullRequestsConfig{
+			Actions:  []string{"opened", "synchronize", "ready_for_review"},
+			Debounce: defaultPullRequestDebounce,
+		},
+		CITriage: ciTriageConfig{
+			LogLines: defaultCITriageLogLines,
+		},
+		Releases: releasesConfig{
+			Actions: []string{"published"},
+		},
+		PromptInjection: promptInjectionConfig{
+			Policy: injectionPolicyFlag,
+		},
+	}
+}
+
+func (c *pullRequestsConfig) triggersOn(action string) bool {
+	return c.Enabled && slices.Contains(c.Actions, action)
+}
+
+func parseRepoConfig(b []byte) (*repoConfig, error) {
+	c := defaultRepoConfig()
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) { // An empty file is fine.
+		return nil, err
+	}
+	var errs []error
+	if c.PullRequests.Debounce < 0 {
+		errs = append(errs, errors.New("pullRequests.debounce must be non-negative"))
+	}
+	if c.CITriage.LogLines <= 0 {
+		errs = append(errs, errors.New("ciTriage.logLines must be positive"))
+	}
+	for _, a := range c.Releases.Actions {
+		if !slices.Contains(releaseActions, a) {
+			errs = append(errs, fmt.Errorf("releases.actions: unsupported action %q; must be one of %v", a, releaseActions))
+		}
+	}
+	if !slices.Contains(injectionPolicies, c.PromptInjection.Policy) {
+		errs = append(errs, fmt.Errorf("promptInjection.policy: unsupported policy %q; must be one of %v", c.PromptInjection.Policy, injectionPolicies))
+	}
+	names := map[string]bool{}
+	for i, t := range c.Push.Tasks {
+		if err := t.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("push.tasks[%d]: %w", i, err))
+		}
+		if names[t.Name] {
+			errs = append(errs, fmt.Errorf("push.tasks[%d]: duplicate name %q", i, t.Name))
+		}
+		names[t.Name] = true
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type repoConfigEntry struct {
+	config *repoConfig
+	expiry time.Time
+}
+
+// repoConfigs caches parsed repository configs by "<owner>/<repo>".
+type repoConfigs struct {
+	mu      sync.Mutex
+	entries map[string]repoConfigEntry
+}
+
+func newRepoConfigs() *repoConfigs {
+	return &repoConfigs{entries: make(map[string]repoConfigEntry)}
+}
+
+// repoConfig fetches and caches the configuration for a repository. An invalid
+// file is reported as an error rather than silently falling back to defaults.
+func (s *Service) repoConfig(ctx context.Context, installationID int64, owner, repo string) (*repoConfig, error) {
+	key := owner + "/" + repo
+	if c, ok := s.cachedRepoConfig(key); ok {
+		return c, nil
+	}
+
+	ghClient, err := s.githubClient(ctx, installationID)
+	if err != nil {
+		return nil, fmt.Errorf("creating github client: %v", err)
+	}
+
+	c := defaultRepoConfig()
+	file, _, resp, err := ghClient.Repositories.GetContents(ctx, owner, repo, repoConfigPath, nil)
+	switch {
+	case statusCode(resp) == http.StatusNotFound:
+		// No config; use defaults.
+	case err != nil:
+		return nil, fmt.Errorf("getting %s: %w", repoConfigPath, err)
+	default:
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", repoConfigPath, err)
+		}
+		if c, err = parseRepoConfig([]byte(content)); err != nil {
+			return nil, fmt.Errorf("parsing %s in %s: %w", repoConfigPath, key, err)
+		}
+	}
+
+	s.repoConfigs.mu.Lock()
+	defer s.repoConfigs.mu.Unlock()
+	s.repoConfigs.entries[key] = repoConfigEntry{config: c, expiry: time.Now().Add(repoConfigTTL)}
+	return c, nil
+}
+
+// cachedRepoConfig returns the cached config for "<owner>/<repo>", if fresh.
+func (s *Service) cachedRepoConfig(fullName string) (*repoConfig, bool) {
+	s.repoConfigs.mu.Lock()
+	defer s.repoConfigs.mu.Unlock()
+
+	e, ok := s.repoConfigs.entries[fullName]
+	if !ok || e.expiry.Before(time.Now()) {
+		return nil, false
+	}
+	return e.config, true
+}
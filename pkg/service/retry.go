@@ -0,0 +1,192 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/squee1945/pillar-service/pkg/logger"
+)
+
+const (
+	defaultGitHubMaxAttempts = 5
+	defaultGitHubBaseBackoff = 500 * time.Millisecond
+	defaultGitHubMaxBackoff  = 30 * time.Second
+	// Rate limit resets further away than this are returned to the caller
+	// rather than waited out. Requests with a deadline, such as those of a
+	// webhook delivery, only wait if the retry can happen before it.
+	maxGitHubRateLimitWait = 2 * time.Minute
+	maxPeekBody            = 64 * 1024
+)
+
+// retryTransport retries GitHub API requests that fail with transient errors
+// using jittered exponential backoff. Primary and secondary rate limits are
+// retried for every request; 5xx responses and network errors only for
+// idempotent methods, since GitHub may have applied a POST or PATCH (e.g.,
+// created a comment) before failing. Retry-After and X-RateLimit-Reset are honoured.
+// A wait that would outlast the request context's deadline is not started;
+// the response is returned at once.
+type retryTransport struct {
+	base        http.RoundTripper
+	log         logger.L
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+}
+
+func newRetryTransport(base http.RoundTripper, log logger.L) *retryTransport {
+	return &retryTransport{
+		base:        base,
+		log:         log,
+		maxAttempts: defaultGitHubMaxAttempts,
+		baseBackoff: defaultGitHubBaseBackoff,
+		maxBackoff:  defaultGitHubMaxBackoff,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// The body cannot be replayed, so the request cannot be retried.
+		return t.base.RoundTrip(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 {
+			r = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, fmt.Errorf("replaying request body: %w", err)
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err := t.base.RoundTrip(r)
+		wait, retry := t.shouldRetry(attempt, req.Method, resp, err)
+		if !retry || attempt >= t.maxAttempts {
+			return resp, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			t.log.Warn(ctx, "GitHub request %s %s not retried; waiting %s would pass the request deadline", req.Method, req.URL.Path, wait)
+			return resp, err
+		}
+
+		if err != nil {
+			t.log.Warn(ctx, "GitHub request %s %s failed (attempt %d/%d), retrying in %s: %v", req.Method, req.URL.Path, attempt, t.maxAttempts, wait, err)
+		} else {
+			t.log.Warn(ctx, "GitHub request %s %s returned %d (attempt %d/%d), retrying in %s", req.Method, req.URL.Path, resp.StatusCode, attempt, t.maxAttempts, wait)
+			// Drain so the connection can be reused.
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxPeekBody))
+			resp.Body.Close()
+		}
+
+		if err := sleepCtx(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// shouldRetry decides whether a response is transient, and how long to wait.
+func (t *retryTransport) shouldRetry(attempt int, method string, resp *http.Response, err error) (time.Duration, bool) {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || !idempotent(method) {
+			return 0, false
+		}
+		return t.backoff(attempt), true
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+	case resp.StatusCode == http.StatusForbidden && isRateLimited(resp):
+	case resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented && idempotent(method):
+	default:
+		return 0, false
+	}
+
+	if wait, ok := rateLimitWait(resp, time.Now()); ok {
+		if wait > maxGitHubRateLimitWait {
+			return 0, false
+		}
+		return wait, true
+	}
+	return t.backoff(attempt), true
+}
+
+// idempotent reports whether repeating a request with method has no further
+// effect, so that it can be retried after an unknown outcome.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// backoff returns a full-jitter exponential delay for the given attempt.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	d := t.baseBackoff << (attempt - 1)
+	if d <= 0 || d > t.maxBackoff {
+		d = t.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// isRateLimited reports whether a 403 response is a primary or secondary
+// rate limit, as opposed to a permissions error. The body is restored so the
+// caller can still read it.
+func isRateLimited(resp *http.Response) bool {
+	if resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return true
+	}
+	if resp.Body == nil {
+		return false
+	}
+	peek, _ := io.ReadAll(io.LimitReader(resp.Body, maxPeekBody))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peek), resp.Body), resp.Body}
+	return strings.Contains(strings.ToLower(string(peek)), "secondary rate limit")
+}
+
+// rateLimitWait extracts the server-requested wait from Retry-After or
+// X-RateLimit-Reset.
+func rateLimitWait(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if ra := resp.Header.Get("Retry-After"); ra != "" {
+		if secs, err := strconv.Atoi(ra); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+		if at, err := http.ParseTime(ra); err == nil {
+			return max(at.Sub(now), 0), true
+		}
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return max(time.Unix(reset, 0).Sub(now), 0) + time.Second, true
+		}
+	}
+	return 0, false
+}
+
+// sleepCtx waits for d, returning early with the context's error if it is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
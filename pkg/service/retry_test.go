@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/squee1945/pillar-service/pkg/logger"
+)
+
+func TestRateLimitWait(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "no headers"},
+		{name: "retry-after seconds", header: map[string]string{"Retry-After": "30"}, want: 30 * time.Second, wantOK: true},
+		{name: "retry-after zero", header: map[string]string{"Retry-After": "0"}, want: 0, wantOK: true},
+		{name: "retry-after date", header: map[string]string{"Retry-After": now.Add(time.Minute).Format(http.TimeFormat)}, want: time.Minute, wantOK: true},
+		{name: "retry-after past date", header: map[string]string{"Retry-After": now.Add(-time.Minute).Format(http.TimeFormat)}, want: 0, wantOK: true},
+		{name: "retry-after negative", header: map[string]string{"Retry-After": "-5"}},
+		{name: "retry-after garbage", header: map[string]string{"Retry-After": "soon"}},
+		{
+			name:   "rate limit reset",
+			header: map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(now.Add(10*time.Second).Unix(), 10)},
+			want:   11 * time.Second,
+			wantOK: true,
+		},
+		{
+			name:   "rate limit reset in the past",
+			header: map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)},
+			want:   time.Second,
+			wantOK: true,
+		},
+		{
+			name:   "rate limit not exhausted",
+			header: map[string]string{"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": strconv.FormatInt(now.Add(time.Minute).Unix(), 10)},
+		},
+		{
+			name:   "retry-after wins over reset",
+			header: map[string]string{"Retry-After": "5", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(now.Add(time.Minute).Unix(), 10)},
+			want:   5 * time.Second,
+			wantOK: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			for k, v := range tc.header {
+				resp.Header.Set(k, v)
+			}
+			got, ok := rateLimitWait(resp, now)
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("rateLimitWait() = %s, %t; want %s, %t", got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	tests := map[string]bool{
+		http.MethodGet:     true,
+		http.MethodHead:    true,
+		http.MethodOptions: true,
+		http.MethodPut:     true,
+		http.MethodDelete:  true,
+		http.MethodPost:    false,
+		http.MethodPatch:   false,
+	}
+	for method, want := range tests {
+		if got := idempotent(method); got != want {
+			t.Errorf("idempotent(%s) = %t, want %t", method, got, want)
+		}
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	response := func(status int, header map[string]string, body string) *http.Response {
+		resp := &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+		for k, v := range header {
+			resp.Header.Set(k, v)
+		}
+		return resp
+	}
+	tests := []struct {
+		name     string
+		method   string
+		resp     *http.Response
+		err      error
+		want     bool
+		wantWait time.Duration // Checked if non-zero.
+	}{
+		{name: "success", method: http.MethodGet, resp: response(200, nil, "")},
+		{name: "not found", method: http.MethodGet, resp: response(404, nil, "")},
+		{name: "server error get", method: http.MethodGet, resp: response(502, nil, ""), want: true},
+		{name: "server error post", method: http.MethodPost, resp: response(502, nil, "")},
+		{name: "not implemented", method: http.MethodGet, resp: response(501, nil, "")},
+		{name: "network error get", method: http.MethodGet, err: errors.New("connection reset"), want: true},
+		{name: "network error post", method: http.MethodPost, err: errors.New("connection reset")},
+		{name: "cancelled", method: http.MethodGet, err: context.Canceled},
+		{name: "too many requests post", method: http.MethodPost, resp: response(429, map[string]string{"Retry-After": "3"}, ""), want: true, wantWait: 3 * time.Second},
+		{name: "primary rate limit", method: http.MethodPatch, resp: response(403, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "0"}, ""), want: true},
+		{name: "secondary rate limit", method: http.MethodPost, resp: response(403, nil, `{"message": "You have exceeded a secondary rate limit."}`), want: true},
+		{name: "forbidden", method: http.MethodGet, resp: response(403, nil, `{"message": "Resource not accessible by integration"}`)},
+		{name: "wait too long", method: http.MethodGet, resp: response(429, map[string]string{"Retry-After": "3600"}, "")},
+	}
+	rt := newRetryTransport(nil, logger.New())
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			wait, got := rt.shouldRetry(1, tc.method, tc.resp, tc.err)
+			if got != tc.want {
+				t.Fatalf("shouldRetry() = %t, want %t", got, tc.want)
+			}
+			if tc.wantWait != 0 && wait != tc.wantWait {
+				t.Errorf("wait = %s, want %s", wait, tc.wantWait)
+			}
+		})
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestRetryTransportDeadline(t *testing.T) {
+	tests := []struct {
+		name         string
+		retryAfter   string
+		wantAttempts int
+		wantStatus   int
+	}{
+		{name: "wait within the deadline", retryAfter: "0", wantAttempts: 2, wantStatus: http.StatusOK},
+		{name: "wait past the deadline", retryAfter: "30", wantAttempts: 1, wantStatus: http.StatusTooManyRequests},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			rt := newRetryTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				attempts++
+				if attempts == 1 {
+					return &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {tc.retryAfter}}, Body: http.NoBody}, nil
+				}
+				return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+			}), logger.New())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.github.com/repos/octo/app", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			resp, err := rt.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("RoundTrip() = %v", err)
+			}
+			if resp.StatusCode != tc.wantStatus || attempts != tc.wantAttempts {
+				t.Errorf("got status %d after %d attempt(s), want %d after %d", resp.StatusCode, attempts, tc.wantStatus, tc.wantAttempts)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("RoundTrip() took %s, want it to return at once", elapsed)
+			}
+		})
+	}
+}
@@ -2,29 +2,124 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/v75/github"
+	"github.com/google/uuid"
+	"github.com/squee1945/pillar-service/pkg/jobs"
 	"github.com/squee1945/pillar-service/pkg/runner"
 )
 
-func (s *Service) run(ctx context.Context, installationID int64, repo *github.Repository, prompt string, configOpts ...configOption) error {
-	cfg, err := s.runnerConfig(ctx, installationID, repo)
+// jobTrigger describes what caused a run, for the job record.
+type jobTrigger struct {
+	name string // E.g., "release/published".
+	url  string // The GitHub release, issue or pull request involved.
+}
+
+// errPromptRefused is returned by run for a prompt that the repository's
+// prompt injection policy refuses; the job is recorded but not run.
+var errPromptRefused = errors.New("prompt refused: untrusted content matches known prompt injection patterns")
+
+// run launches a runner against repo and records it as a job.
+func (s *Service) run(ctx context.Context, trigger jobTrigger, installationID int64, repo *github.Repository, prompt renderedPrompt, configOpts ...configOption) error {
+	perms, err := permissionsFor(trigger.name)
+	if err != nil {
+		return err
+	}
+
+	uid, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("generating job ID: %v", err)
+	}
+	job := &jobs.Job{
+		ID:             uid.String(),
+		Trigger:        trigger.name,
+		TriggerURL:     trigger.url,
+		DeliveryID:     deliveryIDFromContext(ctx),
+		InstallationID: installationID,
+		RepoID:         repo.GetID(),
+		Owner:          repo.GetOwner().GetLogin(),
+		Repo:           repo.GetName(),
+		Status:         jobs.StatusPending,
+		Prompt:         prompt.text,
+		Guardrail:      prompt.guardrail,
+	}
+
+	// A refused prompt is recorded without minting a token for it.
+	if prompt.guardrail != nil && prompt.guardrail.Decision == jobs.DecisionRefused {
+		job.Status = jobs.StatusRefused
+		job.Error = errPromptRefused.Error()
+		if err := s.Jobs.Create(ctx, job); err != nil {
+			return fmt.Errorf("recording job: %v", err)
+		}
+		s.Log.Warn(ctx, "Refused job %s (%s) against %s: %v", job.ID, trigger.name, job.FullName(), errPromptRefused)
+		return errPromptRefused
+	}
+
+	cfg, err := s.runnerConfig(ctx, installationID, repo, perms)
 	if err != nil {
-		return fmt.Errorf("generating runner config: %v", err)
+		err = fmt.Errorf("generating runner config: %v", err)
+		s.recordFailedJob(ctx, job, err)
+		return err
 	}
 
 	for _, o := range configOpts {
 		o(&cfg)
 	}
 
-	cfg.Prompt = prompt
+	cfg.Prompt = prompt.text
+
+	job.Owner, job.Repo = cfg.Owner, cfg.Repo
+	job.Settings = cfg.Redacted()
+	job.Settings.Prompt = "" // Recorded separately.
+	if err := s.Jobs.Create(ctx, job); err != nil {
+		return fmt.Errorf("recording job: %v", err)
+	}
+
+	return s.launch(ctx, job.ID, cfg)
+}
+
+// recordFailedJob records job as failed with err, for failures before a runner
+// could be launched.
+func (s *Service) recordFailedJob(ctx context.Context, job *jobs.Job, err error) {
+	job.Status = jobs.StatusFailed
+	job.Error = err.Error()
+	if err := s.Jobs.Create(ctx, job); err != nil {
+		s.Log.Warn(ctx, "Failed to record failed job %s, continuing: %v", job.ID, err)
+	}
+}
+
+// launch starts a runner build for an existing job and records the outcome.
+func (s *Service) launch(ctx context.Context, jobID string, cfg runner.Config) error {
+	buildID, runErr := s.startRunner(ctx, cfg)
 
+	_, err := s.Jobs.Update(ctx, jobID, func(j *jobs.Job) error {
+		j.Attempts++
+		if runErr != nil {
+			j.Status = jobs.StatusFailed
+			j.Error = runErr.Error()
+			return nil
+		}
+		j.Status = jobs.StatusRunning
+		j.Error = ""
+		j.BuildIDs = append(j.BuildIDs, buildID)
+		return nil
+	})
+	if err != nil {
+		s.Log.Warn(ctx, "Failed to record outcome of job %s, continuing: %v", jobID, err)
+	}
+	return runErr
+}
+
+func (s *Service) startRunner(ctx context.Context, cfg runner.Config) (string, error) {
 	r, err := runner.New(ctx, cfg)
 	if err != nil {
-		return fmt.Errorf("creating runner: %v", err)
+		return "", fmt.Errorf("creating runner: %v", err)
 	}
 	return r.Run(ctx)
 }
@@ -43,18 +138,24 @@ func (s *Service) runnerConfigBase(ctx context.Context) (runner.Config, error) {
 		KMSKeyName:               s.KMSKeyName,
 		ServiceAccount:           s.RunnerServiceAccount,
 		PrepImage:                s.PrepImage,
-		DevBranch:                fmt.Sprintf("%s-%d-%s", s.ServiceName, time.Now().Unix(), randomString(4)),
+		DevBranch:                s.newDevBranch(),
 		PromptImage:              s.PromptImage,
 		GeminiAPIKey:             string(geminiAPIKey),
 		SubBuildServiceAccount:   s.SubBuildServiceAccount,
 		SubBuildLogsBucket:       s.SubBuildLogsBucket,
 		SubBuildTestOutputBucket: s.SubBuildTestOutputBucket,
 		SubBuildGoRepository:     s.SubBuildGoRepository,
+		SubBuildMavenRepository:  s.SubBuildMavenRepository,
+		SubBuildNpmRepository:    s.SubBuildNpmRepository,
+		SubBuildPythonRepository: s.SubBuildPythonRepository,
+		SubBuildPolicyURL:        s.SubBuildPolicyURL,
 	}, nil
 }
 
-func (s *Service) runnerConfig(ctx context.Context, installationID int64, repo *github.Repository) (runner.Config, error) {
-	githubToken, err := s.installationToken(ctx, installationID, withRepoIDs(repo.GetID()))
+// runnerConfig returns the config for a runner against repo, with a token
+// restricted to that repository and the given permissions.
+func (s *Service) runnerConfig(ctx context.Context, installationID int64, repo *github.Repository, perms *github.InstallationPermissions) (runner.Config, error) {
+	githubToken, err := s.installationToken(ctx, installationID, withRepoIDs(repo.GetID()), withPermissions(perms))
 	if err != nil {
 		return runner.Config{}, fmt.Errorf("generating installation token: %v", err)
 	}
@@ -96,7 +197,54 @@ func withGithubExcludeTools(tools []string) configOption {
 	}
 }
 
-const consonants = "bcdfghjklmnpqrstvwxyz"
+// withBranch makes the runner check out branch rather than the repository's
+// default branch.
+func withBranch(branch string) configOption {
+	return func(cfg *runner.Config) {
+		cfg.DefaultBranch = branch
+	}
+}
+
+// withSettingsFrom copies the optional settings of a previous run, so that a
+// retried job behaves like the original.
+func withSettingsFrom(prev runner.Config) configOption {
+	return func(cfg *runner.Config) {
+		cfg.DefaultBranch = prev.DefaultBranch
+		cfg.RunnerTimeout = prev.RunnerTimeout
+		cfg.GeminiMaxSessionTurns = prev.GeminiMaxSessionTurns
+		cfg.DevHelperIncludeTools = prev.DevHelperIncludeTools
+		cfg.DevHelperExcludeTools = prev.DevHelperExcludeTools
+		cfg.DevHelperMCPTimeout = prev.DevHelperMCPTimeout
+		cfg.GithubIncludeTools = prev.GithubIncludeTools
+		cfg.GithubExcludeTools = prev.GithubExcludeTools
+		cfg.GithubMCPTimeout = prev.GithubMCPTimeout
+	}
+}
+
+const (
+	consonants       = "bcdfghjklmnpqrstvwxyz"
+	devBranchRandLen = 4
+)
+
+// newDevBranch returns a name for the branch a runner works on, e.g.,
+// "pillar-1700000000-bcdf".
+func (s *Service) newDevBranch() string {
+	return fmt.Sprintf("%s-%d-%s", s.ServiceName, time.Now().Unix(), randomString(devBranchRandLen))
+}
+
+// isDevBranch reports whether branch was named by newDevBranch.
+func (s *Service) isDevBranch(branch string) bool {
+	rest, ok := strings.CutPrefix(branch, s.ServiceName+"-")
+	if !ok {
+		return false
+	}
+	ts, suffix, ok := strings.Cut(rest, "-")
+	if !ok || len(suffix) != devBranchRandLen || strings.Trim(suffix, consonants) != "" {
+		return false
+	}
+	_, err := strconv.ParseInt(ts, 10, 64)
+	return err == nil
+}
 
 func randomString(n int) string {
 	if n <= 0 {
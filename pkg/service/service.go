@@ -2,9 +2,17 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	htmltemplate "html/template"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"text/template"
+
+	"github.com/squee1945/pillar-service/pkg/jobs"
+	"github.com/squee1945/pillar-service/pkg/runner"
+	"google.golang.org/api/idtoken"
 )
 
 const (
@@ -14,39 +22,77 @@ const (
 type Service struct {
 	Config
 
-	prompts *template.Template
+	githubTransport  http.RoundTripper
+	githubClients    *githubClients
+	repoConfigs      *repoConfigs
+	debouncer        *debouncer
+	releaseBatches   *releaseBatches
+	prompts          *template.Template // The embedded templates.
+	promptSources    *promptSources
+	dashboard        *htmltemplate.Template
+	idTokenValidator *idtoken.Validator
+	draining         atomic.Bool
+	background       sync.WaitGroup // Work outliving its request, e.g. onboarding; Flush waits for it.
 }
 
 func New(ctx context.Context, cfg Config) (*Service, error) {
-	if err := cfg.validate(); err != nil {
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
+	if cfg.Secrets == nil {
+		return nil, errors.New("Secrets must be set")
+	}
 	if cfg.Transport == nil {
 		cfg.Transport = http.DefaultTransport
 	}
 	if cfg.ServiceName == "" {
 		cfg.ServiceName = defaultServiceName
 	}
+	if cfg.Jobs == nil {
+		cfg.Jobs = jobs.NewMemory(0)
+	}
 
 	prompts, err := parsePromptTemplates(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("parsing templates: %w", err)
 	}
 
-	return &Service{Config: cfg, prompts: prompts}, nil
+	dashboard, err := parseDashboardTemplates(ctx, cfg.ProjectID, cfg.Region)
+	if err != nil {
+		return nil, fmt.Errorf("parsing dashboard templates: %w", err)
+	}
+
+	s := &Service{
+		Config:          cfg,
+		githubTransport: newRetryTransport(cfg.Transport, cfg.Log),
+		githubClients:   newGithubClients(runner.DefaultRunnerTimeout),
+		repoConfigs:     newRepoConfigs(),
+		debouncer:       newDebouncer(),
+		releaseBatches:  newReleaseBatches(),
+		promptSources:   newPromptSources(),
+		prompts:         prompts,
+		dashboard:       dashboard,
+	}
+
+	if cfg.AdminAuthBypass {
+		cfg.Log.Warn(ctx, "Admin API authentication is bypassed; do not use this in production.")
+	} else if cfg.AdminAudience != "" {
+		v, err := idtoken.NewValidator(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("creating ID token validator: %w", err)
+		}
+		s.idTokenValidator = v
+	}
+
+	return s, nil
 }
 
 func (s *Service) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.Handle("/webhook", http.HandlerFunc(s.webhook))
-	mux.Handle("/", http.HandlerFunc(s.indexHandler))
+	mux.Handle("/healthz", http.HandlerFunc(s.healthzHandler))
+	mux.Handle("/readyz", http.HandlerFunc(s.readyzHandler))
+	s.registerAdminHandlers(mux)
+	mux.Handle("GET /{$}", s.adminAuth(http.HandlerFunc(s.dashboardHandler)))
 	return mux
 }
-
-func (s *Service) indexHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
-	}
-	w.Write([]byte("Hello"))
-}
@@ -1,65 +1,250 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
 	"github.com/google/go-github/v75/github"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
 	tokenExchangeTimeout = 30 * time.Second
+
+	// Scoped tokens are handed to runners, which may use them for the whole
+	// runner timeout after waiting in the build queue; a cached token is only
+	// reused with the runner timeout plus this margin left.
+	scopedTokenMargin = 10 * time.Minute
+
+	// privateKeyTTL is how often the app private key is re-read, to pick up a
+	// rotation.
+	privateKeyTTL = time.Minute
 )
 
-func (s *Service) githubClient(ctx context.Context, installationID int64) (*github.Client, error) {
-	privateKey, err := s.Secrets.Read(ctx, s.AppPrivateKeySecretName)
+// githubClients caches GitHub clients and scoped installation tokens per
+// installation. Everything is rebuilt if the app private key changes. mu only
+// guards the fields; secrets are read and tokens minted without holding it.
+type githubClients struct {
+	mu         sync.Mutex
+	privateKey []byte
+	keyExpiry  time.Time
+	apps       *ghinstallation.AppsTransport
+	appClient  *github.Client
+	clients    map[int64]*github.Client
+	tokens     map[string]*github.InstallationToken
+	generation uint64 // Incremented whenever cached tokens are discarded.
+	botLogin   string // The app's bot user, e.g., "pillar[bot]"; see appBotLogin.
+
+	// minValidity is the life a cached token must have left to be reused.
+	minValidity time.Duration
+
+	// group deduplicates concurrent private key reads and token exchanges.
+	group singleflight.Group
+}
+
+func newGithubClients(runnerTimeout time.Duration) *githubClients {
+	return &githubClients{
+		clients:     make(map[int64]*github.Client),
+		tokens:      make(map[string]*github.InstallationToken),
+		minValidity: runnerTimeout + scopedTokenMargin,
+	}
+}
+
+// appsTransport returns the app-level (JWT) transport and client. The private
+// key is re-read at most every privateKeyTTL; if it has changed, the cached
+// clients and tokens are discarded.
+func (s *Service) appsTransport(ctx context.Context) (*ghinstallation.AppsTransport, *github.Client, error) {
+	c := s.githubClients
+	c.mu.Lock()
+	apps, appClient := c.apps, c.appClient
+	fresh := apps != nil && time.Now().Before(c.keyExpiry)
+	c.mu.Unlock()
+	if fresh {
+		return apps, appClient, nil
+	}
+
+	v, err, _ := c.group.Do("privateKey", func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenExchangeTimeout)
+		defer cancel()
+		return s.Secrets.Read(ctx, s.AppPrivateKeySecretName)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("reading private key: %v", err)
+		return nil, nil, fmt.Errorf("reading private key: %v", err)
 	}
+	privateKey := v.([]byte)
 
-	tr, err := ghinstallation.New(s.Transport, s.AppID, installationID, privateKey)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keyExpiry = time.Now().Add(privateKeyTTL)
+	if c.apps != nil && bytes.Equal(privateKey, c.privateKey) {
+		return c.apps, c.appClient, nil
+	}
+
+	tr, err := ghinstallation.NewAppsTransport(s.githubTransport, s.AppID, privateKey)
 	if err != nil {
-		return nil, fmt.Errorf("creating transport: %v", err)
+		return nil, nil, fmt.Errorf("creating transport: %v", err)
+	}
+	if c.apps != nil {
+		s.Log.Info(ctx, "App private key changed; discarding cached GitHub clients and tokens")
 	}
-	return github.NewClient(&http.Client{Transport: tr}), nil
+	c.privateKey = privateKey
+	c.apps = tr
+	c.appClient = github.NewClient(&http.Client{Transport: tr})
+	clear(c.clients)
+	clear(c.tokens)
+	c.generation++
+	return c.apps, c.appClient, nil
 }
 
-func (s *Service) installationToken(ctx context.Context, installationID int64, opts ...installationTokenOption) (string, error) {
-	privateKey, err := s.Secrets.Read(ctx, s.AppPrivateKeySecretName)
-	if err != nil {
-		return "", fmt.Errorf("reading private key: %v", err)
+// githubClient returns a client authenticated as the given installation. The
+// underlying transport mints and refreshes installation tokens as needed.
+func (s *Service) githubClient(ctx context.Context, installationID int64) (*github.Client, error) {
+	if _, _, err := s.appsTransport(ctx); err != nil {
+		return nil, err
 	}
 
-	tr, err := ghinstallation.NewAppsTransport(s.Transport, s.AppID, privateKey)
-	if err != nil {
-		return "", fmt.Errorf("creating transport: %v", err)
+	c := s.githubClients
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.clients[installationID]; ok {
+		return client, nil
 	}
 
-	ghClient := github.NewClient(&http.Client{Transport: tr})
+	tr := ghinstallation.NewFromAppsTransport(c.apps, installationID)
+	client := github.NewClient(&http.Client{Transport: tr})
+	c.clients[installationID] = client
+	return client, nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), tokenExchangeTimeout)
-	defer cancel()
+// appBotLogin returns the login of the app's bot user, which authors the
+// app's comments, pushes and pull requests.
+func (s *Service) appBotLogin(ctx context.Context) (string, error) {
+	c := s.githubClients
+	c.mu.Lock()
+	login := c.botLogin
+	c.mu.Unlock()
+	if login != "" {
+		return login, nil
+	}
 
+	_, appClient, err := s.appsTransport(ctx)
+	if err != nil {
+		return "", err
+	}
+	app, _, err := appClient.Apps.Get(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("getting app: %v", err)
+	}
+	login = app.GetSlug() + "[bot]"
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.botLogin = login
+	return login, nil
+}
+
+// installationToken returns a token for the installation, restricted by
+// opts. Tokens are cached by installation, repositories and permissions, and
+// reused until they approach expiry. Concurrent requests for the same token
+// share one exchange.
+func (s *Service) installationToken(ctx context.Context, installationID int64, opts ...installationTokenOption) (string, error) {
 	itops := &github.InstallationTokenOptions{}
 	for _, opt := range opts {
 		opt(itops)
 	}
+	key, err := tokenCacheKey(installationID, itops)
+	if err != nil {
+		return "", err
+	}
+
+	_, appClient, err := s.appsTransport(ctx)
+	if err != nil {
+		return "", err
+	}
 
-	token, resp, err := ghClient.Apps.CreateInstallationToken(ctx, installationID, itops)
-	defer resp.Body.Close()
+	c := s.githubClients
+	c.mu.Lock()
+	token, ok := c.tokens[key]
+	generation := c.generation
+	c.mu.Unlock()
+	if ok && time.Until(token.GetExpiresAt().Time) > c.minValidity {
+		return token.GetToken(), nil
+	}
+
+	v, err, _ := c.group.Do("token|"+key, func() (any, error) {
+		// Shared by all the callers, so not bound to the first one's context.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenExchangeTimeout)
+		defer cancel()
+		token, _, err := appClient.Apps.CreateInstallationToken(ctx, installationID, itops)
+		if err != nil {
+			return nil, fmt.Errorf("creating installation token: %v", err)
+		}
+		if token == nil {
+			return nil, fmt.Errorf("installation token is nil")
+		}
+		if token.Token == nil {
+			return nil, fmt.Errorf("installation token.Token is nil")
+		}
+		return token, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("creating installation token: %v", err)
+		return "", err
+	}
+	token = v.(*github.InstallationToken)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, t := range c.tokens {
+		if time.Until(t.GetExpiresAt().Time) <= c.minValidity {
+			delete(c.tokens, k)
+		}
 	}
-	if token == nil {
-		return "", fmt.Errorf("installation token is nil")
+	// Don't cache a token minted across an invalidation of the installation or
+	// a key change.
+	if c.generation == generation {
+		c.tokens[key] = token
 	}
-	if token.Token == nil {
-		return "", fmt.Errorf("installation token.Token is nil")
+	return token.GetToken(), nil
+}
+
+// invalidateInstallation drops cached clients and tokens for an installation,
+// e.g., after it is deleted or suspended.
+func (s *Service) invalidateInstallation(ctx context.Context, installationID int64) {
+	c := s.githubClients
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.clients, installationID)
+	c.generation++
+	prefix := strconv.FormatInt(installationID, 10) + "|"
+	for key := range c.tokens {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.tokens, key)
+		}
+	}
+	s.Log.Info(ctx, "Invalidated cached GitHub credentials for installation %d", installationID)
+}
+
+func tokenCacheKey(installationID int64, opts *github.InstallationTokenOptions) (string, error) {
+	repoIDs := slices.Clone(opts.RepositoryIDs)
+	slices.Sort(repoIDs)
+	repos := slices.Clone(opts.Repositories)
+	slices.Sort(repos)
+	// Marshalling omits unset permissions, so equivalent sets produce equal keys.
+	perms, err := json.Marshal(opts.Permissions)
+	if err != nil {
+		return "", fmt.Errorf("marshalling permissions: %v", err)
 	}
-	return *token.Token, nil
+	return fmt.Sprintf("%d|%v|%v|%s", installationID, repoIDs, repos, perms), nil
 }
 
 type installationTokenOption func(*github.InstallationTokenOptions)
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/google/go-github/v75/github"
+	"github.com/squee1945/pillar-service/pkg/logger"
+)
+
+// newTokenTestService returns a service whose GitHub app mints tokens valid for
+// lifetime, counting the exchanges in *exchanges.
+func newTokenTestService(t *testing.T, lifetime time.Duration, exchanges *atomic.Int32) *Service {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	tr := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/access_tokens") {
+			return nil, fmt.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		n := exchanges.Add(1)
+		body := fmt.Sprintf(`{"token": "token-%d", "expires_at": %q}`, n, time.Now().Add(lifetime).Format(time.RFC3339))
+		return &http.Response{StatusCode: http.StatusCreated, Header: http.Header{"Content-Type": {"application/json"}}, Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})
+	apps, err := ghinstallation.NewAppsTransport(tr, 1, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Service{Config: Config{Log: logger.New()}, githubClients: newGithubClients(20 * time.Minute)}
+	c := s.githubClients
+	c.privateKey = privateKey
+	c.keyExpiry = time.Now().Add(time.Hour)
+	c.apps = apps
+	c.appClient = github.NewClient(&http.Client{Transport: apps})
+	return s
+}
+
+func TestInstallationTokenCache(t *testing.T) {
+	ctx := context.Background()
+	contents := withPermissions(&github.InstallationPermissions{Contents: github.Ptr("write")})
+	issues := withPermissions(&github.InstallationPermissions{Issues: github.Ptr("write")})
+
+	tests := []struct {
+		name          string
+		lifetime      time.Duration
+		second        []installationTokenOption
+		invalidate    bool
+		wantExchanges int32
+	}{
+		{name: "cache hit", lifetime: time.Hour, second: []installationTokenOption{contents, withRepoIDs(2, 1)}, wantExchanges: 1},
+		{name: "other permissions", lifetime: time.Hour, second: []installationTokenOption{issues, withRepoIDs(1, 2)}, wantExchanges: 2},
+		{name: "other repos", lifetime: time.Hour, second: []installationTokenOption{contents, withRepoIDs(1)}, wantExchanges: 2},
+		{name: "too close to expiry", lifetime: 25 * time.Minute, second: []installationTokenOption{contents, withRepoIDs(1, 2)}, wantExchanges: 2},
+		{name: "invalidated", lifetime: time.Hour, second: []installationTokenOption{contents, withRepoIDs(1, 2)}, invalidate: true, wantExchanges: 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var exchanges atomic.Int32
+			s := newTokenTestService(t, tc.lifetime, &exchanges)
+
+			first, err := s.installationToken(ctx, 42, contents, withRepoIDs(1, 2))
+			if err != nil {
+				t.Fatalf("installationToken() = %v", err)
+			}
+			if tc.invalidate {
+				s.invalidateInstallation(ctx, 42)
+			}
+			second, err := s.installationToken(ctx, 42, tc.second...)
+			if err != nil {
+				t.Fatalf("installationToken() = %v", err)
+			}
+
+			if got := exchanges.Load(); got != tc.wantExchanges {
+				t.Errorf("token exchanges = %d, want %d", got, tc.wantExchanges)
+			}
+			if reused := first == second; reused != (tc.wantExchanges == 1) {
+				t.Errorf("second token %q, first %q; want reused %t", second, first, tc.wantExchanges == 1)
+			}
+		})
+	}
+}
+
+func TestInstallationTokenInvalidateOtherInstallation(t *testing.T) {
+	ctx := context.Background()
+	var exchanges atomic.Int32
+	s := newTokenTestService(t, time.Hour, &exchanges)
+
+	if _, err := s.installationToken(ctx, 42, withRepoIDs(1)); err != nil {
+		t.Fatalf("installationToken() = %v", err)
+	}
+	s.invalidateInstallation(ctx, 7)
+	if _, err := s.installationToken(ctx, 42, withRepoIDs(1)); err != nil {
+		t.Fatalf("installationToken() = %v", err)
+	}
+	if got := exchanges.Load(); got != 1 {
+		t.Errorf("token exchanges = %d, want 1", got)
+	}
+}
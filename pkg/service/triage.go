@@ -0,0 +1,284 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v75/github"
+)
+
+const (
+	// Automatic fixes stop after this many attempts on a pull request, so that
+	// a fix that fails CI again cannot trigger fixes forever. Attempts are
+	// counted from the app's comments on the pull request (see triageFixes).
+	maxTriageFixesPerPR = 3
+
+	jobLogTimeout = 30 * time.Second
+	// Only the tail of a job log is kept, but logs can be large; stop reading
+	// after this much.
+	maxJobLogBytes = 16 * 1024 * 1024
+
+	githubActionsAppSlug = "github-actions"
+)
+
+// ciFailure is a failed CI job, with an excerpt of its log or output.
+type ciFailure struct {
+	Name       string
+	URL        string
+	Conclusion string
+	Log        string
+}
+
+// ciRun identifies the CI run whose failures are being triaged.
+type ciRun struct {
+	kind    string // "workflow_run" or "check_run".
+	name    string
+	url     string
+	headSHA string
+	prs     []*github.PullRequest
+}
+
+func isCIFailure(conclusion string) bool {
+	return conclusion == "failure" || conclusion == "timed_out"
+}
+
+func (s *Service) workflowRunHandler(ctx context.Context, event *github.WorkflowRunEvent) error {
+	run := event.GetWorkflowRun()
+	if event.GetAction() != "completed" || !isCIFailure(run.GetConclusion()) {
+		s.Log.Debug(ctx, "Ignoring workflow_run %s event (conclusion %q)", event.GetAction(), run.GetConclusion())
+		return nil
+	}
+
+	owner, repo := event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName()
+	cr := ciRun{kind: "workflow_run", name: run.GetName(), url: run.GetHTMLURL(), headSHA: run.GetHeadSHA(), prs: run.PullRequests}
+	return s.triageCI(ctx, event.GetInstallation().GetID(), event.GetRepo(), cr, func(ghClient *github.Client, logLines int) ([]ciFailure, error) {
+		var failures []ciFailure
+		opts := &github.ListWorkflowJobsOptions{Filter: "latest", ListOptions: github.ListOptions{PerPage: 100}}
+		for {
+			page, resp, err := ghClient.Actions.ListWorkflowJobs(ctx, owner, repo, run.GetID(), opts)
+			if err != nil {
+				return nil, fmt.Errorf("listing jobs of workflow run %d: %w", run.GetID(), err)
+			}
+			for _, job := range page.Jobs {
+				if !isCIFailure(job.GetConclusion()) {
+					continue
+				}
+				log, err := s.jobLogTail(ctx, ghClient, owner, repo, job.GetID(), logLines)
+				if err != nil {
+					s.Log.Warn(ctx, "Failed to fetch log of job %d (repo %s/%s), continuing: %v", job.GetID(), owner, repo, err)
+					log = fmt.Sprintf("(log unavailable: %v)", err)
+				}
+				failures = append(failures, ciFailure{Name: job.GetName(), URL: job.GetHTMLURL(), Conclusion: job.GetConclusion(), Log: log})
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opts.Page = resp.NextPage
+		}
+		return failures, nil
+	})
+}
+
+func (s *Service) checkRunHandler(ctx context.Context, event *github.CheckRunEvent) error {
+	check := event.GetCheckRun()
+	if event.GetAction() != "completed" || !isCIFailure(check.GetConclusion()) {
+		s.Log.Debug(ctx, "Ignoring check_run %s event (conclusion %q)", event.GetAction(), check.GetConclusion())
+		return nil
+	}
+	if check.GetApp().GetSlug() == githubActionsAppSlug {
+		// Triaged from the workflow_run event instead, which has the job logs.
+		s.Log.Debug(ctx, "Ignoring check_run %d from GitHub Actions", check.GetID())
+		return nil
+	}
+
+	cr := ciRun{kind: "check_run", name: check.GetName(), url: check.GetHTMLURL(), headSHA: check.GetHeadSHA(), prs: check.PullRequests}
+	return s.triageCI(ctx, event.GetInstallation().GetID(), event.GetRepo(), cr, func(_ *github.Client, logLines int) ([]ciFailure, error) {
+		output := check.GetOutput()
+		text := strings.Join(slices.DeleteFunc([]string{output.GetTitle(), output.GetSummary(), output.GetText()}, func(s string) bool { return s == "" }), "\n\n")
+		return []ciFailure{{Name: check.GetName(), URL: check.GetDetailsURL(), Conclusion: check.GetConclusion(), Log: tailLines(text, logLines)}}, nil
+	})
+}
+
+// triageCI runs an agent that diagnoses the failures of a CI run on an open
+// pull request, and comments with the root cause and suggested fix. If the
+// repository enables it, the agent pushes a fix instead.
+func (s *Service) triageCI(ctx context.Context, installationID int64, repo *github.Repository, run ciRun, failuresFn func(*github.Client, int) ([]ciFailure, error)) error {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+
+	cfg, err := s.repoConfig(ctx, installationID, owner, name)
+	if err != nil {
+		return fmt.Errorf("loading repo config: %v", err)
+	}
+	triageCfg := cfg.CITriage
+	if !triageCfg.Enabled {
+		s.Log.Debug(ctx, "Ignoring failed %s %q (repo %s/%s); CI triage is not enabled in %s.", run.kind, run.name, owner, name, repoConfigPath)
+		return nil
+	}
+	if len(run.prs) == 0 {
+		s.Log.Debug(ctx, "Ignoring failed %s %q (repo %s/%s); not associated with a pull request.", run.kind, run.name, owner, name)
+		return nil
+	}
+
+	ghClient, err := s.githubClient(ctx, installationID)
+	if err != nil {
+		return fmt.Errorf("creating github client: %v", err)
+	}
+	number := run.prs[0].GetNumber()
+	pr, _, err := ghClient.PullRequests.Get(ctx, owner, name, number)
+	if err != nil {
+		return fmt.Errorf("getting pull request %d: %w", number, err)
+	}
+	if pr.GetState() != "open" || pr.GetHead().GetSHA() != run.headSHA {
+		s.Log.Debug(ctx, "Ignoring failed %s %q (PR %d, repo %s/%s); pull request is closed or has moved on.", run.kind, run.name, number, owner, name)
+		return nil
+	}
+
+	failures, err := failuresFn(ghClient, triageCfg.LogLines)
+	if err != nil {
+		return err
+	}
+	if len(failures) == 0 {
+		s.Log.Debug(ctx, "Ignoring failed %s %q (PR %d, repo %s/%s); no failed jobs found.", run.kind, run.name, number, owner, name)
+		return nil
+	}
+
+	sameRepo := pr.GetHead().GetRepo().GetID() == repo.GetID()
+	pushFix := triageCfg.PushFixes && sameRepo
+	if pushFix {
+		fixes, err := s.triageFixes(ctx, ghClient, owner, name, number)
+		if err != nil {
+			return err
+		}
+		if fixes >= maxTriageFixesPerPR {
+			s.Log.Info(ctx, "Not pushing a fix for PR %d (repo %s/%s); already attempted %d.", number, owner, name, fixes)
+			pushFix = false
+		} else {
+			// The comment records the attempt, so it must be posted first.
+			body := fmt.Sprintf("%s\nAttempting to fix the CI failures at %s (attempt %d of %d).", s.triageFixMarker(), run.headSHA, fixes+1, maxTriageFixesPerPR)
+			if _, _, err := ghClient.Issues.CreateComment(ctx, owner, name, number, &github.IssueComment{Body: github.Ptr(body)}); err != nil {
+				return fmt.Errorf("recording fix attempt on pull request %d: %w", number, err)
+			}
+		}
+	}
+
+	prompt, err := s.renderPrompt(ctx, installationID, owner, name, &promptCITriage{pullRequest: pr, run: run, failures: failures, pushFix: pushFix})
+	if err != nil {
+		return fmt.Errorf("rendering prompt: %v", err)
+	}
+
+	var triggerName string
+	switch {
+	case run.kind == "workflow_run" && pushFix:
+		triggerName = triggerTriageFixWorkflowRun
+	case run.kind == "workflow_run":
+		triggerName = triggerTriageWorkflowRun
+	case pushFix:
+		triggerName = triggerTriageFixCheckRun
+	default:
+		triggerName = triggerTriageCheckRun
+	}
+	trigger := jobTrigger{name: triggerName, url: pr.GetHTMLURL()}
+
+	var opts []configOption
+	if sameRepo {
+		opts = append(opts, withBranch(pr.GetHead().GetRef()))
+	}
+	s.Log.Info(ctx, "Triaging failed %s %q on PR %d (repo %s/%s, push fix: %t)", run.kind, run.name, number, owner, name, pushFix)
+	return s.run(ctx, trigger, installationID, repo, prompt, opts...)
+}
+
+// triageFixMarker marks the app's comments that record a fix attempt.
+func (s *Service) triageFixMarker() string {
+	return "<!-- " + s.ServiceName + ":ci-triage-fix -->"
+}
+
+// triageFixes counts the fixes already attempted on a pull request, from the
+// app's comments that carry triageFixMarker. Unlike the job store, comments
+// survive restarts and are shared by all instances.
+func (s *Service) triageFixes(ctx context.Context, ghClient *github.Client, owner, repo string, number int) (int, error) {
+	botLogin, err := s.appBotLogin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	marker := s.triageFixMarker()
+	n := 0
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := ghClient.Issues.ListComments(ctx, owner, repo, number, opts)
+		if err != nil {
+			return 0, fmt.Errorf("listing comments of pull request %d: %w", number, err)
+		}
+		for _, c := range comments {
+			if c.GetUser().GetLogin() == botLogin && strings.HasPrefix(c.GetBody(), marker) {
+				n++
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return n, nil
+}
+
+// jobLogTail returns the last n lines of a workflow job's log.
+func (s *Service) jobLogTail(ctx context.Context, ghClient *github.Client, owner, repo string, jobID int64, n int) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, jobLogTimeout)
+	defer cancel()
+
+	logURL, _, err := ghClient.Actions.GetWorkflowJobLogs(ctx, owner, repo, jobID, 3)
+	if err != nil {
+		return "", fmt.Errorf("getting log URL: %v", err)
+	}
+
+	// The log URL is pre-signed, so no GitHub credentials are needed.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, logURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := (&http.Client{Transport: s.githubTransport}).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("downloading log: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading log: status %d", resp.StatusCode)
+	}
+
+	var lines []string
+	total := 0
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxJobLogBytes))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		total++
+		lines = append(lines, scanner.Text())
+		if len(lines) > 2*n {
+			lines = slices.Delete(lines, 0, len(lines)-n)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("reading log: %v", err)
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	if omitted := total - len(lines); omitted > 0 {
+		lines = append([]string{fmt.Sprintf("... (%d lines omitted)", omitted)}, lines...)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// tailLines returns the last n lines of s.
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = append([]string{fmt.Sprintf("... (%d lines omitted)", len(lines)-n)}, lines[len(lines)-n:]...)
+	}
+	return strings.Join(lines, "\n")
+}
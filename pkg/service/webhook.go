@@ -1,12 +1,23 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/google/go-github/v75/github"
+	"github.com/squee1945/pillar-service/pkg/jobs"
 )
 
+// webhookTimeout bounds the handling of a delivery. GitHub gives up on a
+// delivery after 10 seconds, so handlers stop a little earlier, failing fast
+// rather than waiting out rate limits, and the error is still reported. Work
+// that outlives the request uses context.WithoutCancel, which drops the
+// deadline.
+const webhookTimeout = 8 * time.Second
+
 func (s *Service) webhook(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -40,32 +51,129 @@ func (s *Service) webhook(w http.ResponseWriter, r *http.Request) {
 	}
 	s.Log.Debug(ctx, "Received event:\n%s", eventJSON)
 
+	deliveryID := github.DeliveryID(r)
+	ctx = withDeliveryID(ctx, deliveryID)
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	var handlerErr error
+	outcome := jobs.OutcomeHandled
+
 	switch event := event.(type) {
 
 	case *github.PushEvent:
 		s.Log.Debug(ctx, "Received push %s event (repo: %q commitURL: %s)", event.GetAction(), event.GetRepo().GetFullName(), event.GetHeadCommit().GetURL())
+		if err := s.pushEventHandler(ctx, event); err != nil {
+			handlerErr = fmt.Errorf("push event handler: %v", err)
+		}
 
 	case *github.PullRequestEvent:
 		s.Log.Debug(ctx, "Received pullRequest %s event (repo: %q pullRequest: %d)", event.GetAction(), event.GetRepo().GetFullName(), event.GetPullRequest().GetNumber())
+		if err := s.pullRequestEventHandler(ctx, event); err != nil {
+			handlerErr = fmt.Errorf("pullRequest event handler: %v", err)
+		}
+
+	case *github.PullRequestReviewCommentEvent:
+		s.Log.Debug(ctx, "Received pullRequestReviewComment %s event (repo: %q pullRequest: %d comment: %d)", event.GetAction(), event.GetRepo().GetFullName(), event.GetPullRequest().GetNumber(), event.GetComment().GetID())
+		if err := s.pullRequestReviewCommentHandler(ctx, event); err != nil {
+			handlerErr = fmt.Errorf("pullRequestReviewComment event handler: %v", err)
+		}
+
+	case *github.PullRequestReviewEvent:
+		s.Log.Debug(ctx, "Received pullRequestReview %s event (repo: %q pullRequest: %d review: %d)", event.GetAction(), event.GetRepo().GetFullName(), event.GetPullRequest().GetNumber(), event.GetReview().GetID())
+		if err := s.pullRequestReviewHandler(ctx, event); err != nil {
+			handlerErr = fmt.Errorf("pullRequestReview event handler: %v", err)
+		}
+
+	case *github.WorkflowRunEvent:
+		s.Log.Debug(ctx, "Received workflowRun %s event (repo: %q run: %d conclusion: %q)", event.GetAction(), event.GetRepo().GetFullName(), event.GetWorkflowRun().GetID(), event.GetWorkflowRun().GetConclusion())
+		if err := s.workflowRunHandler(ctx, event); err != nil {
+			handlerErr = fmt.Errorf("workflowRun event handler: %v", err)
+		}
+
+	case *github.CheckRunEvent:
+		s.Log.Debug(ctx, "Received checkRun %s event (repo: %q check: %d conclusion: %q)", event.GetAction(), event.GetRepo().GetFullName(), event.GetCheckRun().GetID(), event.GetCheckRun().GetConclusion())
+		if err := s.checkRunHandler(ctx, event); err != nil {
+			handlerErr = fmt.Errorf("checkRun event handler: %v", err)
+		}
 
 	case *github.ReleaseEvent:
 		s.Log.Debug(ctx, "Received release %s event (repo: %q release: %q)", event.GetAction(), event.GetRepo().GetFullName(), event.GetRelease().GetName())
-		if err := s.releaseEventHandler(ctx, event); err != nil {
-			s.serverError(w, r, http.StatusInternalServerError, "release event handler: %v", err)
-			return
+		if err := s.releaseEventHandler(ctx, event, payload); err != nil {
+			handlerErr = fmt.Errorf("release event handler: %v", err)
+		}
+
+	case *github.CreateEvent:
+		s.Log.Debug(ctx, "Received create event (repo: %q %s: %q)", event.GetRepo().GetFullName(), event.GetRefType(), event.GetRef())
+		if err := s.createEventHandler(ctx, event); err != nil {
+			handlerErr = fmt.Errorf("create event handler: %v", err)
 		}
 
 	case *github.IssueCommentEvent:
 		s.Log.Debug(ctx, "Received issueComment %s event (repo: %q issue: %d comment: %d)", event.GetAction(), event.GetRepo().GetFullName(), event.GetIssue().GetNumber(), event.GetComment().GetID())
 		if err := s.issueCommentHandler(ctx, event); err != nil {
-			s.serverError(w, r, http.StatusInternalServerError, "issueComment event handler: %v", err)
-			return
+			handlerErr = fmt.Errorf("issueComment event handler: %v", err)
+		}
+
+	case *github.IssuesEvent:
+		s.Log.Debug(ctx, "Received issues %s event (repo: %q issue: %d)", event.GetAction(), event.GetRepo().GetFullName(), event.GetIssue().GetNumber())
+		if err := s.issuesEventHandler(ctx, event); err != nil {
+			handlerErr = fmt.Errorf("issues event handler: %v", err)
+		}
+
+	case *github.InstallationEvent:
+		s.Log.Debug(ctx, "Received installation %s event (installation: %d account: %q)", event.GetAction(), event.GetInstallation().GetID(), event.GetInstallation().GetAccount().GetLogin())
+		if err := s.installationEventHandler(ctx, event); err != nil {
+			handlerErr = fmt.Errorf("installation event handler: %v", err)
+		}
+
+	case *github.InstallationRepositoriesEvent:
+		s.Log.Debug(ctx, "Received installationRepositories %s event (installation: %d account: %q)", event.GetAction(), event.GetInstallation().GetID(), event.GetInstallation().GetAccount().GetLogin())
+		if err := s.installationRepositoriesEventHandler(ctx, event); err != nil {
+			handlerErr = fmt.Errorf("installationRepositories event handler: %v", err)
 		}
 
 	default:
 		s.Log.Info(ctx, "Received unhandled event type: %s", github.WebHookType(r))
+		outcome = jobs.OutcomeUnhandled
+	}
+
+	delivery := &jobs.Delivery{
+		ID:         deliveryID,
+		ReceivedAt: time.Now(),
+		Event:      github.WebHookType(r),
+		Outcome:    outcome,
+	}
+	if e, ok := event.(interface{ GetAction() string }); ok {
+		delivery.Action = e.GetAction()
+	}
+	if e, ok := event.(interface{ GetRepo() *github.Repository }); ok {
+		delivery.Repo = e.GetRepo().GetFullName()
+	}
+	if handlerErr != nil {
+		delivery.Outcome = jobs.OutcomeError
+		delivery.Detail = handlerErr.Error()
+	}
+	if err := s.Jobs.AddDelivery(ctx, delivery); err != nil {
+		s.Log.Warn(ctx, "Failed to record delivery %s, continuing: %v", deliveryID, err)
+	}
+
+	if handlerErr != nil {
+		s.serverError(w, r, http.StatusInternalServerError, "%v", handlerErr)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("ok"))
 }
+
+type deliveryIDKey struct{}
+
+func withDeliveryID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, deliveryIDKey{}, id)
+}
+
+func deliveryIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(deliveryIDKey{}).(string)
+	return id
+}
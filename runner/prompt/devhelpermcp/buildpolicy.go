@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	cloudbuildpb "cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
+)
+
+const maxBuildPolicySize = 1 << 20
+
+// buildPolicy restricts the builds that the agent can create. It is read from
+// a JSON file (see loadBuildPolicy); fields that are not set keep their
+// defaults.
+type buildPolicy struct {
+	// AllowedImages are the builder images that steps can run, as path.Match
+	// patterns for the image without its tag or digest. Images on Docker Hub
+	// are matched by their short name, e.g. "golang" or "bitnami/*".
+	AllowedImages []string `json:"allowedImages"`
+	// MaxSteps caps the number of steps.
+	MaxSteps int `json:"maxSteps"`
+	// MaxTimeout caps the build timeout, e.g. "15m". Builds without a timeout
+	// are given this one. It should be shorter than the runner build's timeout
+	// (20 minutes by default), which waits for the sub-builds.
+	MaxTimeout duration `json:"maxTimeout"`
+	// AllowedMachineTypes are the machine types that options.machineType can
+	// request, e.g. "E2_HIGHCPU_8". The default machine type is always allowed.
+	AllowedMachineTypes []string `json:"allowedMachineTypes"`
+	// MaxDiskSizeGB caps options.diskSizeGb.
+	MaxDiskSizeGB int64 `json:"maxDiskSizeGb"`
+}
+
+var defaultBuildPolicy = buildPolicy{
+	AllowedImages: []string{
+		"golang", "node", "python", "maven", "gradle", "eclipse-temurin", "openjdk",
+		"rust", "ubuntu", "debian", "alpine", "busybox", "bash",
+		"gcr.io/cloud-builders/git", "gcr.io/cloud-builders/go", "gcr.io/cloud-builders/npm",
+		"gcr.io/cloud-builders/mvn", "gcr.io/cloud-builders/gradle",
+	},
+	MaxSteps:      20,
+	MaxTimeout:    duration(15 * time.Minute),
+	MaxDiskSizeGB: 100,
+}
+
+// duration is a time.Duration written as a string in JSON, e.g. "15m".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"15m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// loadBuildPolicy reads the policy at source, a local file or a gs://bucket/object
+// URL. An empty source gives the default policy.
+func loadBuildPolicy(ctx context.Context, source string) (buildPolicy, error) {
+	policy := defaultBuildPolicy
+	if source == "" {
+		return policy, nil
+	}
+
+	var r io.ReadCloser
+	if rest, ok := strings.CutPrefix(source, "gs://"); ok {
+		bucket, object, _ := strings.Cut(rest, "/")
+		storageClient, err := storage.NewClient(ctx)
+		if err != nil {
+			return buildPolicy{}, fmt.Errorf("storage.NewClient: %w", err)
+		}
+		defer storageClient.Close()
+		if r, err = storageClient.Bucket(bucket).Object(object).NewReader(ctx); err != nil {
+			return buildPolicy{}, fmt.Errorf("opening build policy %s: %w", source, err)
+		}
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return buildPolicy{}, fmt.Errorf("opening build policy: %w", err)
+		}
+		r = f
+	}
+	defer r.Close()
+
+	dec := json.NewDecoder(io.LimitReader(r, maxBuildPolicySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		return buildPolicy{}, fmt.Errorf("parsing build policy %s: %w", source, err)
+	}
+	for _, p := range policy.AllowedImages {
+		if _, err := path.Match(p, ""); err != nil {
+			return buildPolicy{}, fmt.Errorf("build policy %s: bad allowedImages pattern %q: %w", source, p, err)
+		}
+	}
+	return policy, nil
+}
+
+// buildDestinations are where a build is allowed to write artifacts. The
+// repositories are Artifact Registry repository names in projectID and region;
+// artifacts of an ecosystem without one are not allowed.
+type buildDestinations struct {
+	projectID, region string
+	testOutputBucket  string
+	goRepository      string
+	mavenRepository   string
+	npmRepository     string
+	pythonRepository  string
+}
+
+// repositoryURL returns the URL of an Artifact Registry repository of the
+// given format (e.g. "maven"), as written in Maven, npm and Python artifacts.
+func (d buildDestinations) repositoryURL(format, repo string) string {
+	return fmt.Sprintf("https://%s-%s.pkg.dev/%s/%s", d.region, format, d.projectID, repo)
+}
+
+// check returns the ways in which build violates the policy, or nil if it does
+// not. A missing timeout is set to the maximum.
+func (p buildPolicy) check(build *cloudbuildpb.Build, dest buildDestinations) []string {
+	var violations []string
+	violate := func(format string, args ...any) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+
+	if n := len(build.GetSteps()); n == 0 {
+		violate("the build has no steps")
+	} else if p.MaxSteps > 0 && n > p.MaxSteps {
+		violate("the build has %d steps; at most %d are allowed", n, p.MaxSteps)
+	}
+	for i, step := range build.GetSteps() {
+		if !p.allowedImage(step.GetName()) {
+			violate("step %d: image %q is not allowed; use one of: %s", i, step.GetName(), strings.Join(p.AllowedImages, ", "))
+		}
+		if len(step.GetSecretEnv()) > 0 {
+			violate("step %d: secretEnv is not allowed", i)
+		}
+		if len(step.GetVolumes()) > 0 {
+			violate("step %d: volumes is not allowed", i)
+		}
+	}
+
+	maxTimeout := time.Duration(p.MaxTimeout)
+	switch {
+	case maxTimeout <= 0:
+	case build.GetTimeout() == nil:
+		build.Timeout = durationpb.New(maxTimeout)
+	case build.GetTimeout().AsDuration() > maxTimeout:
+		violate("timeout %s is longer than the maximum of %s", build.GetTimeout().AsDuration(), maxTimeout)
+	}
+	for i, step := range build.GetSteps() {
+		if maxTimeout > 0 && step.GetTimeout().AsDuration() > maxTimeout {
+			violate("step %d: timeout %s is longer than the maximum of %s", i, step.GetTimeout().AsDuration(), maxTimeout)
+		}
+	}
+
+	if build.GetAvailableSecrets() != nil {
+		violate("availableSecrets is not allowed")
+	}
+	if len(build.GetSecrets()) > 0 {
+		violate("secrets is not allowed")
+	}
+	if opts := build.GetOptions(); opts != nil {
+		if len(opts.GetSecretEnv()) > 0 {
+			violate("options.secretEnv is not allowed")
+		}
+		if opts.GetPool() != nil {
+			violate("options.pool is not allowed")
+		}
+		if opts.GetWorkerPool() != "" {
+			violate("options.workerPool is not allowed")
+		}
+		if len(opts.GetVolumes()) > 0 {
+			violate("options.volumes is not allowed")
+		}
+		if len(opts.GetEnv()) > 0 {
+			violate("options.env is not allowed; set env on the steps that need it")
+		}
+		if mt := opts.GetMachineType(); mt != cloudbuildpb.BuildOptions_UNSPECIFIED && !slices.Contains(p.AllowedMachineTypes, mt.String()) {
+			violate("options.machineType %s is not allowed", mt)
+		}
+		if p.MaxDiskSizeGB > 0 && opts.GetDiskSizeGb() > p.MaxDiskSizeGB {
+			violate("options.diskSizeGb %d is larger than the maximum of %d", opts.GetDiskSizeGb(), p.MaxDiskSizeGB)
+		}
+	}
+
+	if len(build.GetImages()) > 0 {
+		violate("images is not allowed; upload language artifacts in artifacts instead")
+	}
+	if a := build.GetArtifacts(); a != nil {
+		if len(a.GetImages()) > 0 {
+			violate("artifacts.images is not allowed")
+		}
+		if loc := a.GetObjects().GetLocation(); loc != "" && !strings.HasPrefix(loc, "gs://"+dest.testOutputBucket+"/") && loc != "gs://"+dest.testOutputBucket {
+			violate("artifacts.objects.location must be gs://%s/", dest.testOutputBucket)
+		}
+		for i, m := range a.GetGoModules() {
+			if dest.goRepository == "" {
+				violate("artifacts.goModules is not allowed; no Go repository is configured")
+				break
+			}
+			if m.GetRepositoryName() != dest.goRepository || m.GetRepositoryLocation() != dest.region || m.GetRepositoryProjectId() != dest.projectID {
+				violate("artifacts.goModules[%d] must use repositoryName %q, repositoryLocation %q and repositoryProjectId %q", i, dest.goRepository, dest.region, dest.projectID)
+			}
+		}
+		checkRepository := func(field, format, repo string, got []string) {
+			if len(got) == 0 {
+				return
+			}
+			if repo == "" {
+				violate("artifacts.%s is not allowed; no %s repository is configured", field, format)
+				return
+			}
+			want := dest.repositoryURL(format, repo)
+			for i, r := range got {
+				if strings.TrimSuffix(r, "/") != want {
+					violate("artifacts.%s[%d].repository must be %q", field, i, want)
+				}
+			}
+		}
+		var maven, npm, python []string
+		for _, m := range a.GetMavenArtifacts() {
+			maven = append(maven, m.GetRepository())
+		}
+		for _, p := range a.GetNpmPackages() {
+			npm = append(npm, p.GetRepository())
+		}
+		for _, p := range a.GetPythonPackages() {
+			python = append(python, p.GetRepository())
+		}
+		checkRepository("mavenArtifacts", "maven", dest.mavenRepository, maven)
+		checkRepository("npmPackages", "npm", dest.npmRepository, npm)
+		checkRepository("pythonPackages", "python", dest.pythonRepository, python)
+	}
+	return violations
+}
+
+// allowedImage reports whether a step can run image.
+func (p buildPolicy) allowedImage(image string) bool {
+	name := imageName(image)
+	for _, pattern := range p.AllowedImages {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// imageName returns image without its tag or digest, with Docker Hub images
+// shortened to how they are usually written (e.g. "golang").
+func imageName(image string) string {
+	name, _, _ := strings.Cut(image, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	for _, prefix := range []string{"docker.io/library/", "docker.io/", "index.docker.io/library/", "index.docker.io/"} {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			return rest
+		}
+	}
+	return name
+}
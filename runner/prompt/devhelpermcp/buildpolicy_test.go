@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	cloudbuildpb "cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
+)
+
+func TestImageName(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "golang", want: "golang"},
+		{image: "golang:1.23", want: "golang"},
+		{image: "docker.io/library/golang@sha256:abc", want: "golang"},
+		{image: "index.docker.io/library/node:20", want: "node"},
+		{image: "docker.io/bitnami/git:latest", want: "bitnami/git"},
+		{image: "gcr.io/cloud-builders/go", want: "gcr.io/cloud-builders/go"},
+		{image: "localhost:5000/tools", want: "localhost:5000/tools"},
+		{image: "localhost:5000/tools:v1", want: "localhost:5000/tools"},
+	}
+	for _, tc := range tests {
+		if got := imageName(tc.image); got != tc.want {
+			t.Errorf("imageName(%q) = %q, want %q", tc.image, got, tc.want)
+		}
+	}
+}
+
+func TestBuildPolicyCheck(t *testing.T) {
+	policy := buildPolicy{
+		AllowedImages:       []string{"golang", "gcr.io/cloud-builders/*"},
+		MaxSteps:            2,
+		MaxTimeout:          duration(15 * time.Minute),
+		AllowedMachineTypes: []string{"E2_HIGHCPU_8"},
+		MaxDiskSizeGB:       100,
+	}
+	dest := buildDestinations{
+		projectID:        "proj",
+		region:           "us-central1",
+		testOutputBucket: "tests",
+		goRepository:     "go-repo",
+		npmRepository:    "npm-repo",
+	}
+	step := func() *cloudbuildpb.BuildStep { return &cloudbuildpb.BuildStep{Name: "golang:1.23"} }
+
+	tests := []struct {
+		name  string
+		build *cloudbuildpb.Build
+		want  []string // Substrings of the violations, in order.
+	}{
+		{
+			name:  "allowed",
+			build: &cloudbuildpb.Build{Steps: []*cloudbuildpb.BuildStep{step(), {Name: "gcr.io/cloud-builders/git"}}},
+		},
+		{
+			name:  "no steps",
+			build: &cloudbuildpb.Build{},
+			want:  []string{"no steps"},
+		},
+		{
+			name:  "too many steps",
+			build: &cloudbuildpb.Build{Steps: []*cloudbuildpb.BuildStep{step(), step(), step()}},
+			want:  []string{"3 steps"},
+		},
+		{
+			name:  "image",
+			build: &cloudbuildpb.Build{Steps: []*cloudbuildpb.BuildStep{{Name: "evil/image"}}},
+			want:  []string{`image "evil/image" is not allowed`},
+		},
+		{
+			name: "step secrets and volumes",
+			build: &cloudbuildpb.Build{Steps: []*cloudbuildpb.BuildStep{
+				{Name: "golang", SecretEnv: []string{"TOKEN"}, Volumes: []*cloudbuildpb.Volume{{Name: "v", Path: "/v"}}},
+			}},
+			want: []string{"secretEnv", "volumes"},
+		},
+		{
+			name: "timeouts",
+			build: &cloudbuildpb.Build{
+				Steps:   []*cloudbuildpb.BuildStep{{Name: "golang", Timeout: durationpb.New(time.Hour)}},
+				Timeout: durationpb.New(time.Hour),
+			},
+			want: []string{"timeout 1h0m0s", "step 0: timeout 1h0m0s"},
+		},
+		{
+			name: "options",
+			build: &cloudbuildpb.Build{
+				Steps: []*cloudbuildpb.BuildStep{step()},
+				Options: &cloudbuildpb.BuildOptions{
+					SecretEnv:   []string{"TOKEN"},
+					Pool:        &cloudbuildpb.BuildOptions_PoolOption{Name: "pool"},
+					WorkerPool:  "pool",
+					Volumes:     []*cloudbuildpb.Volume{{Name: "v", Path: "/v"}},
+					Env:         []string{"A=B"},
+					MachineType: cloudbuildpb.BuildOptions_E2_HIGHCPU_32,
+					DiskSizeGb:  500,
+				},
+			},
+			want: []string{"options.secretEnv", "options.pool", "options.workerPool", "options.volumes", "options.env", "options.machineType", "options.diskSizeGb"},
+		},
+		{
+			name: "allowed options",
+			build: &cloudbuildpb.Build{
+				Steps:   []*cloudbuildpb.BuildStep{step()},
+				Options: &cloudbuildpb.BuildOptions{MachineType: cloudbuildpb.BuildOptions_E2_HIGHCPU_8, DiskSizeGb: 100},
+			},
+		},
+		{
+			name: "secrets",
+			build: &cloudbuildpb.Build{
+				Steps:            []*cloudbuildpb.BuildStep{step()},
+				AvailableSecrets: &cloudbuildpb.Secrets{},
+				Secrets:          []*cloudbuildpb.Secret{{KmsKeyName: "key"}},
+			},
+			want: []string{"availableSecrets", "secrets"},
+		},
+		{
+			name: "images",
+			build: &cloudbuildpb.Build{
+				Steps:     []*cloudbuildpb.BuildStep{step()},
+				Images:    []string{"gcr.io/proj/app"},
+				Artifacts: &cloudbuildpb.Artifacts{Images: []string{"gcr.io/proj/app"}},
+			},
+			want: []string{"images", "artifacts.images"},
+		},
+		{
+			name: "allowed artifacts",
+			build: &cloudbuildpb.Build{
+				Steps: []*cloudbuildpb.BuildStep{step()},
+				Artifacts: &cloudbuildpb.Artifacts{
+					Objects:     &cloudbuildpb.Artifacts_ArtifactObjects{Location: "gs://tests/123"},
+					GoModules:   []*cloudbuildpb.Artifacts_GoModule{{RepositoryName: "go-repo", RepositoryLocation: "us-central1", RepositoryProjectId: "proj"}},
+					NpmPackages: []*cloudbuildpb.Artifacts_NpmPackage{{Repository: "https://us-central1-npm.pkg.dev/proj/npm-repo/"}},
+				},
+			},
+		},
+		{
+			name: "artifact destinations",
+			build: &cloudbuildpb.Build{
+				Steps: []*cloudbuildpb.BuildStep{step()},
+				Artifacts: &cloudbuildpb.Artifacts{
+					Objects:        &cloudbuildpb.Artifacts_ArtifactObjects{Location: "gs://other/123"},
+					GoModules:      []*cloudbuildpb.Artifacts_GoModule{{RepositoryName: "other", RepositoryLocation: "us-central1", RepositoryProjectId: "proj"}},
+					MavenArtifacts: []*cloudbuildpb.Artifacts_MavenArtifact{{Repository: "https://us-central1-maven.pkg.dev/proj/maven-repo"}},
+					NpmPackages:    []*cloudbuildpb.Artifacts_NpmPackage{{Repository: "https://registry.npmjs.org"}},
+				},
+			},
+			want: []string{"artifacts.objects.location", "artifacts.goModules[0]", "artifacts.mavenArtifacts is not allowed", "artifacts.npmPackages[0].repository"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := policy.check(tc.build, dest)
+			if len(got) != len(tc.want) {
+				t.Fatalf("check() = %q, want %d violations matching %q", got, len(tc.want), tc.want)
+			}
+			for i, v := range got {
+				if !strings.Contains(v, tc.want[i]) {
+					t.Errorf("violation %d = %q, want it to contain %q", i, v, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildPolicyCheckDefaultTimeout(t *testing.T) {
+	build := &cloudbuildpb.Build{Steps: []*cloudbuildpb.BuildStep{{Name: "golang"}}}
+	if v := defaultBuildPolicy.check(build, buildDestinations{}); v != nil {
+		t.Fatalf("check() = %q, want no violations", v)
+	}
+	if got, want := build.GetTimeout().AsDuration(), time.Duration(defaultBuildPolicy.MaxTimeout); got != want {
+		t.Errorf("timeout = %s, want %s", got, want)
+	}
+}
+
+func TestLoadBuildPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    buildPolicy
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			json: `{}`,
+			want: defaultBuildPolicy,
+		},
+		{
+			name: "overrides",
+			json: `{"allowedImages": ["golang"], "maxTimeout": "10m"}`,
+			want: buildPolicy{AllowedImages: []string{"golang"}, MaxSteps: defaultBuildPolicy.MaxSteps, MaxTimeout: duration(10 * time.Minute), MaxDiskSizeGB: defaultBuildPolicy.MaxDiskSizeGB},
+		},
+		{name: "unknown field", json: `{"maxBuilds": 3}`, wantErr: true},
+		{name: "bad duration", json: `{"maxTimeout": 600}`, wantErr: true},
+		{name: "bad pattern", json: `{"allowedImages": ["[golang"]}`, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.json")
+			if err := os.WriteFile(path, []byte(tc.json), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadBuildPolicy(context.Background(), path)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("loadBuildPolicy() error = %v, want error %t", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if !slices.Equal(got.AllowedImages, tc.want.AllowedImages) || got.MaxSteps != tc.want.MaxSteps ||
+				got.MaxTimeout != tc.want.MaxTimeout || got.MaxDiskSizeGB != tc.want.MaxDiskSizeGB ||
+				!slices.Equal(got.AllowedMachineTypes, tc.want.AllowedMachineTypes) {
+				t.Errorf("loadBuildPolicy() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,19 +1,25 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"google.golang.org/genproto/googleapis/grafeas/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 
 	cloudbuild "cloud.google.com/go/cloudbuild/apiv1/v2"
@@ -24,6 +30,24 @@ import (
 const (
 	maxLogs       = 10 * 1024 * 1024
 	maxTestOutput = 10 * 1024 * 1024
+
+	defaultLogTailLines = 50
+	maxLogTailLines     = 500
+	minPollInterval     = 2 * time.Second
+	maxPollInterval     = 30 * time.Second
+
+	defaultLogLimit   = 500
+	maxLogLimit       = 5000
+	errorContextLines = 2
+
+	// waitTimeoutMargin is kept between a wait and the MCP tool timeout, so
+	// that the wait returns timed_out before the client gives up on the call.
+	waitTimeoutMargin = 15 * time.Second
+	// minWaitTimeout is the shortest wait, whatever the tool timeout.
+	minWaitTimeout = 30 * time.Second
+
+	// notFoundGrace is how long a newly created build may not be found.
+	notFoundGrace = time.Minute
 )
 
 type createCloudBuildInput struct {
@@ -54,7 +78,7 @@ type createCloudBuildOutput struct {
 	BuildID string `json:"build_id" jsonschema:"The Build ID of the created build.`
 }
 
-func createCloudBuildTool(githubToken, projectID, region, subBuildServiceAccount, subBuildLogsBucket string) mcp.ToolHandlerFor[createCloudBuildInput, createCloudBuildOutput] {
+func createCloudBuildTool(githubToken, projectID, region, subBuildServiceAccount, subBuildLogsBucket, runnerTag string, policy buildPolicy, dest buildDestinations) mcp.ToolHandlerFor[createCloudBuildInput, createCloudBuildOutput] {
 	return func(ctx context.Context, req *mcp.CallToolRequest, input createCloudBuildInput) (*mcp.CallToolResult, createCloudBuildOutput, error) {
 		if err := input.validate(); err != nil {
 			return nil, createCloudBuildOutput{}, err
@@ -86,8 +110,8 @@ func createCloudBuildTool(githubToken, projectID, region, subBuildServiceAccount
 			build.Options = &cloudbuildpb.BuildOptions{}
 		}
 
-		if build.Artifacts == nil || len(build.Artifacts.GoModules) == 0 {
-			return nil, createCloudBuildOutput{}, errors.New("missing required artifacts.goModules block")
+		if violations := policy.check(&build, dest); len(violations) > 0 {
+			return nil, createCloudBuildOutput{}, fmt.Errorf("the build config violates the build policy; fix the following and try again:\n- %s", strings.Join(violations, "\n- "))
 		}
 
 		build.Options.LogStreamingOption = cloudbuildpb.BuildOptions_STREAM_ON
@@ -95,6 +119,7 @@ func createCloudBuildTool(githubToken, projectID, region, subBuildServiceAccount
 		build.Options.RequestedVerifyOption = cloudbuildpb.BuildOptions_VERIFIED
 		build.LogsBucket = "gs://" + subBuildLogsBucket
 		build.ServiceAccount = subBuildServiceAccount
+		build.Tags = append(build.Tags, runnerTag) // See list_cloud_builds.
 		build.Source = &cloudbuildpb.Source{
 			Source: &cloudbuildpb.Source_GitSource{
 				GitSource: &cloudbuildpb.GitSource{
@@ -175,8 +200,201 @@ func getCloudBuildTool(projectID, region string) mcp.ToolHandlerFor[getCloudBuil
 	}
 }
 
+type waitForCloudBuildInput struct {
+	BuildID        string `json:"build_id" jsonschema:"The Build ID of the build."`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty" jsonschema:"How long to wait for the build to finish, in seconds. Defaults to, and is at most, the longest wait the tool call allows; call again if the wait times out."`
+	LogTailLines   int    `json:"log_tail_lines,omitempty" jsonschema:"The number of log lines to return from the end of the logs of a failed build. Defaults to 50, at most 500."`
+}
+
+func (i waitForCloudBuildInput) validate(maxWait time.Duration) error {
+	var errs []error
+	if i.BuildID == "" {
+		errs = append(errs, errors.New("build_id is required."))
+	}
+	if i.TimeoutSeconds < 0 || time.Duration(i.TimeoutSeconds)*time.Second > maxWait {
+		errs = append(errs, fmt.Errorf("timeout_seconds must be between 0 and %d.", int(maxWait.Seconds())))
+	}
+	if i.LogTailLines < 0 || i.LogTailLines > maxLogTailLines {
+		errs = append(errs, fmt.Errorf("log_tail_lines must be between 0 and %d.", maxLogTailLines))
+	}
+	return errors.Join(errs...)
+}
+
+type waitForCloudBuildOutput struct {
+	BuildID      string     `json:"build_id" jsonschema:"The Build ID of the build."`
+	Status       string     `json:"status" jsonschema:"The build status when the wait ended."`
+	StatusDetail string     `json:"status_detail,omitempty" jsonschema:"Details of the build status, e.g. why it failed."`
+	TimedOut     bool       `json:"timed_out" jsonschema:"True if the wait timed out before the build finished; the build is still running."`
+	Elapsed      string     `json:"elapsed" jsonschema:"How long the wait took."`
+	FailingStep  *buildStep `json:"failing_step,omitempty" jsonschema:"The first step that did not succeed, if the build failed."`
+	LogTail      []string   `json:"log_tail,omitempty" jsonschema:"The last lines of the logs, if the build did not succeed."`
+}
+
+type buildStep struct {
+	Index  int    `json:"index" jsonschema:"The index of the step in the build."`
+	ID     string `json:"id,omitempty" jsonschema:"The id of the step."`
+	Name   string `json:"name" jsonschema:"The image the step ran."`
+	Status string `json:"status" jsonschema:"The step status."`
+}
+
+// maxWaitTimeout returns the longest wait that fits in a tool call limited to
+// toolTimeout.
+func maxWaitTimeout(toolTimeout time.Duration) time.Duration {
+	return max(toolTimeout-waitTimeoutMargin, minWaitTimeout)
+}
+
+func waitForCloudBuildTool(projectID, region string, toolTimeout time.Duration) mcp.ToolHandlerFor[waitForCloudBuildInput, waitForCloudBuildOutput] {
+	maxWait := maxWaitTimeout(toolTimeout)
+	return func(ctx context.Context, req *mcp.CallToolRequest, input waitForCloudBuildInput) (*mcp.CallToolResult, waitForCloudBuildOutput, error) {
+		if err := input.validate(maxWait); err != nil {
+			return nil, waitForCloudBuildOutput{}, err
+		}
+		timeout := cmp.Or(time.Duration(input.TimeoutSeconds)*time.Second, maxWait)
+		tailLines := cmp.Or(input.LogTailLines, defaultLogTailLines)
+
+		start := time.Now()
+		deadline := start.Add(timeout)
+		interval := minPollInterval
+		var build *cloudbuildpb.Build
+		for {
+			b, err := getCloudBuild(ctx, projectID, region, input.BuildID)
+			switch {
+			case status.Code(err) == codes.NotFound && time.Since(start) < notFoundGrace:
+				// A build can take a few seconds to become visible after it is created.
+			case err != nil:
+				return nil, waitForCloudBuildOutput{}, err
+			default:
+				build = b
+			}
+			if build != nil && terminalBuildStatus(build.GetStatus()) {
+				break
+			}
+
+			elapsed := time.Since(start)
+			notifyProgress(ctx, req, elapsed, timeout, fmt.Sprintf("Build %s is %s after %s.", input.BuildID, build.GetStatus(), elapsed.Round(time.Second)))
+
+			wait := min(interval, time.Until(deadline))
+			if wait <= 0 {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return nil, waitForCloudBuildOutput{}, ctx.Err()
+			case <-time.After(wait):
+			}
+			interval = min(interval*3/2, maxPollInterval)
+		}
+
+		if build == nil {
+			return nil, waitForCloudBuildOutput{}, fmt.Errorf("build %s was not found", input.BuildID)
+		}
+
+		output := waitForCloudBuildOutput{
+			BuildID:      input.BuildID,
+			Status:       build.GetStatus().String(),
+			StatusDetail: build.GetStatusDetail(),
+			TimedOut:     !terminalBuildStatus(build.GetStatus()),
+			Elapsed:      time.Since(start).Round(time.Second).String(),
+		}
+		if output.TimedOut || build.GetStatus() == cloudbuildpb.Build_SUCCESS {
+			return nil, output, nil
+		}
+
+		for i, step := range build.GetSteps() {
+			switch step.GetStatus() {
+			case cloudbuildpb.Build_SUCCESS, cloudbuildpb.Build_QUEUED, cloudbuildpb.Build_STATUS_UNKNOWN:
+				continue
+			}
+			output.FailingStep = &buildStep{Index: i, ID: step.GetId(), Name: step.GetName(), Status: step.GetStatus().String()}
+			break
+		}
+
+		lines, _, err := readBuildLogs(ctx, build)
+		if err != nil {
+			// The status is still useful without the logs.
+			fmt.Fprintf(os.Stderr, "Reading logs of build %s: %v\n", input.BuildID, err)
+			return nil, output, nil
+		}
+		output.LogTail = lines[max(len(lines)-tailLines, 0):]
+		return nil, output, nil
+	}
+}
+
+// terminalBuildStatus reports whether a build with status s has finished.
+func terminalBuildStatus(s cloudbuildpb.Build_Status) bool {
+	switch s {
+	case cloudbuildpb.Build_SUCCESS, cloudbuildpb.Build_FAILURE, cloudbuildpb.Build_INTERNAL_ERROR,
+		cloudbuildpb.Build_TIMEOUT, cloudbuildpb.Build_CANCELLED, cloudbuildpb.Build_EXPIRED:
+		return true
+	}
+	return false
+}
+
+// notifyProgress sends a progress notification for req, if the client asked
+// for them.
+func notifyProgress(ctx context.Context, req *mcp.CallToolRequest, elapsed, total time.Duration, message string) {
+	token := req.Params.GetProgressToken()
+	if token == nil {
+		return
+	}
+	params := &mcp.ProgressNotificationParams{
+		ProgressToken: token,
+		Message:       message,
+		Progress:      elapsed.Seconds(),
+		Total:         total.Seconds(),
+	}
+	if err := req.Session.NotifyProgress(ctx, params); err != nil {
+		fmt.Fprintf(os.Stderr, "Sending progress notification: %v\n", err)
+	}
+}
+
+// readBuildLogs returns the lines of the logs of build, which are written to
+// its logs bucket. Only the last maxLogs bytes are read; truncated reports
+// whether earlier lines were dropped.
+func readBuildLogs(ctx context.Context, build *cloudbuildpb.Build) (lines []string, truncated bool, err error) {
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	defer storageClient.Close()
+
+	bucket := strings.TrimPrefix(build.LogsBucket, "gs://")
+	object := fmt.Sprintf("log-%s.txt", build.GetId())
+
+	rc, err := storageClient.Bucket(bucket).Object(object).NewRangeReader(ctx, -maxLogs, -1)
+	if err != nil {
+		return nil, false, fmt.Errorf("getting logs object (bucket %q object %q): %w", bucket, object, err)
+	}
+	defer rc.Close()
+
+	logBlob, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, false, fmt.Errorf("reading logs object (bucket %q object %q): %w", bucket, object, err)
+	}
+	truncated = rc.Attrs.StartOffset > 0
+	return splitLogLines(string(logBlob), truncated), truncated, nil
+}
+
+// splitLogLines splits logs into lines. If the logs are truncated, their first
+// line is partial and is dropped.
+func splitLogLines(logs string, truncated bool) []string {
+	if truncated {
+		_, logs, _ = strings.Cut(logs, "\n")
+	}
+	if logs == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(logs, "\n"), "\n")
+}
+
 type getCloudBuildLogsInput struct {
-	BuildID string `json:"build_id" jsonschema:"The Build ID of the build.`
+	BuildID    string `json:"build_id" jsonschema:"The Build ID of the build."`
+	Step       string `json:"step,omitempty" jsonschema:"Only return the logs of this step, given as its index or its id or name."`
+	Filter     string `json:"filter,omitempty" jsonschema:"Only return lines matching this regular expression (RE2 syntax)."`
+	ErrorsOnly bool   `json:"errors_only,omitempty" jsonschema:"Only return lines that look like errors, with a few lines of context."`
+	Tail       int    `json:"tail,omitempty" jsonschema:"Only return the last N of the selected lines."`
+	Offset     int    `json:"offset,omitempty" jsonschema:"The index of the first selected line to return, for paging."`
+	Limit      int    `json:"limit,omitempty" jsonschema:"The maximum number of lines to return. Defaults to 500, at most 5000."`
 }
 
 func (i getCloudBuildLogsInput) validate() error {
@@ -184,13 +402,45 @@ func (i getCloudBuildLogsInput) validate() error {
 	if i.BuildID == "" {
 		errs = append(errs, errors.New("build_id is required."))
 	}
+	if i.Filter != "" {
+		if _, err := regexp.Compile(i.Filter); err != nil {
+			errs = append(errs, fmt.Errorf("filter is not a valid regular expression: %v", err))
+		}
+	}
+	if i.Tail < 0 {
+		errs = append(errs, errors.New("tail must not be negative."))
+	}
+	if i.Offset < 0 {
+		errs = append(errs, errors.New("offset must not be negative."))
+	}
+	if i.Limit < 0 || i.Limit > maxLogLimit {
+		errs = append(errs, fmt.Errorf("limit must be between 0 and %d.", maxLogLimit))
+	}
 	return errors.Join(errs...)
 }
 
+type logLine struct {
+	Line int    `json:"line" jsonschema:"The line number in the logs, starting at 1; if the logs are truncated, counted from the first line that was kept."`
+	Text string `json:"text" jsonschema:"The log line."`
+}
+
 type getCloudBuildLogsOutput struct {
-	Logs []string `json:"logs" jsonschema:"The log lines."`
+	Status     string    `json:"status" jsonschema:"The build status."`
+	Available  bool      `json:"available" jsonschema:"False if the build has not written any logs yet."`
+	Logs       []logLine `json:"logs" jsonschema:"The selected log lines."`
+	TotalLines int       `json:"total_lines" jsonschema:"The number of lines in the logs."`
+	Truncated  bool      `json:"truncated" jsonschema:"True if the logs were too large and only their end was read; earlier lines are missing."`
+	Selected   int       `json:"selected" jsonschema:"The number of lines selected by step, filter, errors_only and tail, before paging."`
+	NextOffset int       `json:"next_offset,omitempty" jsonschema:"The offset of the next page, if there are more selected lines."`
 }
 
+// logStepPrefix matches the prefix Cloud Build adds to the log lines of a step,
+// e.g. `Step #2 - "test": `, and the lines that start and finish it.
+var logStepPrefix = regexp.MustCompile(`^(?:Starting |Finished )?Step #(\d+)\b`)
+
+// logErrorPattern is the heuristic for errors_only.
+var logErrorPattern = regexp.MustCompile(`(?i)\b(error|errors|failed|failure|fatal|panic|exception|traceback|cannot|undefined|denied)\b|^\S.*\bFAIL\b|--- FAIL`)
+
 func getCloudBuildLogsTool(projectID, region string) mcp.ToolHandlerFor[getCloudBuildLogsInput, getCloudBuildLogsOutput] {
 	return func(ctx context.Context, req *mcp.CallToolRequest, input getCloudBuildLogsInput) (*mcp.CallToolResult, getCloudBuildLogsOutput, error) {
 		if err := input.validate(); err != nil {
@@ -198,34 +448,111 @@ func getCloudBuildLogsTool(projectID, region string) mcp.ToolHandlerFor[getCloud
 		}
 
 		build, err := getCloudBuild(ctx, projectID, region, input.BuildID)
+		if status.Code(err) == codes.NotFound {
+			return nil, getCloudBuildLogsOutput{}, fmt.Errorf("build %s does not exist (if it was just created, try again in a few seconds)", input.BuildID)
+		}
+		if err != nil {
+			return nil, getCloudBuildLogsOutput{}, err
+		}
+		steps, err := logSteps(build, input.Step)
 		if err != nil {
 			return nil, getCloudBuildLogsOutput{}, err
 		}
 
-		storageClient, err := storage.NewClient(ctx)
+		output := getCloudBuildLogsOutput{Status: build.GetStatus().String(), Logs: []logLine{}}
+		lines, truncated, err := readBuildLogs(ctx, build)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			// The build has not started writing logs yet.
+			return nil, output, nil
+		}
 		if err != nil {
-			return nil, getCloudBuildLogsOutput{}, fmt.Errorf("storage.NewClient: %w", err)
+			return nil, getCloudBuildLogsOutput{}, err
 		}
-		defer storageClient.Close()
+		output.Available = true
+		output.Truncated = truncated
+		output.TotalLines = len(lines)
 
-		bucket := strings.TrimPrefix(build.LogsBucket, "gs://")
-		object := fmt.Sprintf("log-%s.txt", input.BuildID)
+		var filter *regexp.Regexp
+		if input.Filter != "" {
+			filter = regexp.MustCompile(input.Filter) // Checked by validate.
+		}
+		selected := selectLogLines(lines, steps, filter, input.ErrorsOnly)
+		if input.Tail > 0 {
+			selected = selected[max(len(selected)-input.Tail, 0):]
+		}
+		output.Selected = len(selected)
 
-		rc, err := storageClient.Bucket(bucket).Object(object).NewReader(ctx)
-		if err != nil {
-			return nil, getCloudBuildLogsOutput{}, fmt.Errorf("getting logs object (bucket %q object %q): %w", bucket, object, err)
+		limit := cmp.Or(input.Limit, defaultLogLimit)
+		start := min(input.Offset, len(selected))
+		end := min(start+limit, len(selected))
+		output.Logs = append(output.Logs, selected[start:end]...)
+		if end < len(selected) {
+			output.NextOffset = end
+		}
+		return nil, output, nil
+	}
+}
+
+// logSteps returns the indexes of the steps of build matching step, which is an
+// index, id or name, or nil if step is empty.
+func logSteps(build *cloudbuildpb.Build, step string) (map[string]bool, error) {
+	if step == "" {
+		return nil, nil
+	}
+	if i, err := strconv.Atoi(step); err == nil {
+		if i < 0 || i >= len(build.GetSteps()) {
+			return nil, fmt.Errorf("step %d does not exist; the build has %d steps", i, len(build.GetSteps()))
 		}
-		defer rc.Close()
+		return map[string]bool{step: true}, nil
+	}
+	steps := make(map[string]bool)
+	for i, s := range build.GetSteps() {
+		if s.GetId() == step || s.GetName() == step {
+			steps[strconv.Itoa(i)] = true
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no step has id or name %q", step)
+	}
+	return steps, nil
+}
 
-		logBlob, err := io.ReadAll(io.LimitReader(rc, maxLogs))
-		lines := strings.Split(string(logBlob), "\n")
+// selectLogLines returns the lines of the given steps (all lines if steps is
+// nil) that match filter. With errorsOnly, only lines that look like errors are
+// kept, with some lines of context.
+func selectLogLines(lines []string, steps map[string]bool, filter *regexp.Regexp, errorsOnly bool) []logLine {
+	var candidates []logLine
+	for i, line := range lines {
+		if steps != nil {
+			m := logStepPrefix.FindStringSubmatch(line)
+			if m == nil || !steps[m[1]] {
+				continue
+			}
+		}
+		candidates = append(candidates, logLine{Line: i + 1, Text: line})
+	}
 
-		output := getCloudBuildLogsOutput{
-			Logs: lines,
+	keep := make([]bool, len(candidates))
+	for i, c := range candidates {
+		switch {
+		case errorsOnly && !logErrorPattern.MatchString(c.Text):
+		case filter != nil && !filter.MatchString(c.Text):
+		case errorsOnly:
+			for j := max(i-errorContextLines, 0); j <= min(i+errorContextLines, len(candidates)-1); j++ {
+				keep[j] = true
+			}
+		default:
+			keep[i] = true
 		}
+	}
 
-		return nil, output, nil
+	selected := []logLine{}
+	for i, c := range candidates {
+		if keep[i] {
+			selected = append(selected, c)
+		}
 	}
+	return selected
 }
 
 type fetchTestOutputInput struct {
@@ -249,22 +576,10 @@ func fetchTestOutputTool(subBuildTestOutputBucket string) mcp.ToolHandlerFor[fet
 		if err := input.validate(); err != nil {
 			return nil, fetchTestOutputOutput{}, err
 		}
-		storageClient, err := storage.NewClient(ctx)
+		blob, err := readTestOutput(ctx, subBuildTestOutputBucket, input.Filename)
 		if err != nil {
-			return nil, fetchTestOutputOutput{}, fmt.Errorf("storage.NewClient: %w", err)
-		}
-		defer storageClient.Close()
-
-		bucket := strings.TrimPrefix(subBuildTestOutputBucket, "gs://")
-		object := input.Filename
-
-		rc, err := storageClient.Bucket(bucket).Object(object).NewReader(ctx)
-		if err != nil {
-			return nil, fetchTestOutputOutput{}, fmt.Errorf("getting logs object (bucket %q object %q): %w", bucket, object, err)
+			return nil, fetchTestOutputOutput{}, err
 		}
-		defer rc.Close()
-
-		blob, err := io.ReadAll(io.LimitReader(rc, maxTestOutput))
 
 		output := fetchTestOutputOutput{
 			TestOutput: string(blob),
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"testing"
+
+	cloudbuildpb "cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
+)
+
+func TestSplitLogLines(t *testing.T) {
+	tests := []struct {
+		logs      string
+		truncated bool
+		want      []string
+	}{
+		{logs: "one\ntwo\n", want: []string{"one", "two"}},
+		{logs: "one\ntwo", want: []string{"one", "two"}},
+		{logs: "ne\ntwo\nthree\n", truncated: true, want: []string{"two", "three"}},
+		{logs: "partial", truncated: true, want: nil},
+		{logs: "", want: nil},
+	}
+	for _, tc := range tests {
+		if got := splitLogLines(tc.logs, tc.truncated); !slices.Equal(got, tc.want) {
+			t.Errorf("splitLogLines(%q, %t) = %q, want %q", tc.logs, tc.truncated, got, tc.want)
+		}
+	}
+}
+
+func TestLogSteps(t *testing.T) {
+	build := &cloudbuildpb.Build{Steps: []*cloudbuildpb.BuildStep{
+		{Id: "fetch", Name: "gcr.io/cloud-builders/git"},
+		{Id: "test", Name: "golang"},
+		{Id: "vet", Name: "golang"},
+	}}
+	tests := []struct {
+		step    string
+		want    map[string]bool
+		wantErr bool
+	}{
+		{step: "", want: nil},
+		{step: "1", want: map[string]bool{"1": true}},
+		{step: "3", wantErr: true},
+		{step: "-1", wantErr: true},
+		{step: "test", want: map[string]bool{"1": true}},
+		{step: "golang", want: map[string]bool{"1": true, "2": true}},
+		{step: "build", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := logSteps(build, tc.step)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("logSteps(%q) error = %v, want error %t", tc.step, err, tc.wantErr)
+			continue
+		}
+		if !maps.Equal(got, tc.want) {
+			t.Errorf("logSteps(%q) = %v, want %v", tc.step, got, tc.want)
+		}
+	}
+}
+
+func TestSelectLogLines(t *testing.T) {
+	lines := []string{
+		`starting build`,
+		`Starting Step #0 - "fetch"`,
+		`Step #0 - "fetch": cloning`,
+		`Finished Step #0 - "fetch"`,
+		`Starting Step #1 - "test"`,
+		`Step #1 - "test": ok pkg/a`,
+		`Step #1 - "test": --- FAIL: TestB`,
+		`Step #1 - "test": FAIL pkg/b`,
+		`Step #1 - "test": exit status 1`,
+		`Finished Step #1 - "test"`,
+		`ERROR: build step 1 failed`,
+	}
+	tests := []struct {
+		name       string
+		steps      map[string]bool
+		filter     string
+		errorsOnly bool
+		want       []int
+	}{
+		{name: "all", want: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+		{name: "step", steps: map[string]bool{"0": true}, want: []int{2, 3, 4}},
+		{name: "filter", filter: `pkg/`, want: []int{6, 8}},
+		{name: "step and filter", steps: map[string]bool{"1": true}, filter: `exit`, want: []int{9}},
+		{name: "errors with context", errorsOnly: true, want: []int{5, 6, 7, 8, 9, 10, 11}},
+		{name: "errors in step", steps: map[string]bool{"0": true}, errorsOnly: true, want: []int{}},
+		{name: "no match", filter: `nothing`, want: []int{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var filter *regexp.Regexp
+			if tc.filter != "" {
+				filter = regexp.MustCompile(tc.filter)
+			}
+			got := []int{}
+			for _, l := range selectLogLines(lines, tc.steps, filter, tc.errorsOnly) {
+				if l.Text != lines[l.Line-1] {
+					t.Errorf("line %d = %q, want %q", l.Line, l.Text, lines[l.Line-1])
+				}
+				got = append(got, l.Line)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("selected lines = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
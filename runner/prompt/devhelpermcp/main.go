@@ -6,6 +6,11 @@ import (
 	"context"
 	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
@@ -15,6 +20,13 @@ var (
 	subBuildServiceAccount   = flag.String("sub_build_service_account", "", "Service account for sub-build")
 	subBuildLogsBucket       = flag.String("sub_build_logs_bucket", "", "Log bucket for sub-build")
 	subBuildTestOutputBucket = flag.String("sub_build_test_output_bucket", "", "Test output bucket for sub-build")
+	subBuildGoRepository     = flag.String("sub_build_go_repository", "", "Artifact Registry repository for Go modules uploaded by sub-builds")
+	subBuildMavenRepository  = flag.String("sub_build_maven_repository", "", "Artifact Registry repository for Maven artifacts uploaded by sub-builds; if empty, they cannot be uploaded")
+	subBuildNpmRepository    = flag.String("sub_build_npm_repository", "", "Artifact Registry repository for npm packages uploaded by sub-builds; if empty, they cannot be uploaded")
+	subBuildPythonRepository = flag.String("sub_build_python_repository", "", "Artifact Registry repository for Python packages uploaded by sub-builds; if empty, they cannot be uploaded")
+	runnerTag                = flag.String("runner_tag", "", "Tag added to sub-builds, to list them and cancel them on shutdown; defaults to a random tag")
+	buildPolicyPath          = flag.String("build_policy", "", "Build policy JSON file (a local path or gs:// URL); defaults to the built-in policy")
+	toolTimeout              = flag.Duration("tool_timeout", 2*time.Minute, "The MCP client's timeout for a tool call; waits end before it")
 	projectID                = flag.String("project_id", "", "The project ID")
 	region                   = flag.String("region", "", "The region")
 )
@@ -38,6 +50,10 @@ func main() {
 		log.Fatal("--sub_build_test_output_bucket is required")
 	}
 
+	if *subBuildGoRepository == "" {
+		log.Fatal("--sub_build_go_repository is required")
+	}
+
 	if *projectID == "" {
 		log.Fatal("--project_id is required")
 	}
@@ -46,6 +62,27 @@ func main() {
 		log.Fatal("--region is required")
 	}
 
+	if *runnerTag == "" {
+		*runnerTag = newRunnerTag()
+	}
+	if !buildTagPattern.MatchString(*runnerTag) {
+		log.Fatalf("--runner_tag must match %s", buildTagPattern)
+	}
+
+	policy, err := loadBuildPolicy(context.Background(), *buildPolicyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dest := buildDestinations{
+		projectID:        *projectID,
+		region:           *region,
+		testOutputBucket: strings.TrimPrefix(*subBuildTestOutputBucket, "gs://"),
+		goRepository:     *subBuildGoRepository,
+		mavenRepository:  *subBuildMavenRepository,
+		npmRepository:    *subBuildNpmRepository,
+		pythonRepository: *subBuildPythonRepository,
+	}
+
 	i := &mcp.Implementation{
 		Name:    "dev_helper",
 		Title:   "Developer Helper - High level tools to assist in creating contributions to GitHub repositories.",
@@ -67,9 +104,9 @@ func main() {
 	mcp.AddTool(server,
 		&mcp.Tool{
 			Name:        "create_cloud_build",
-			Description: "Starts a Google Cloud Build build. The source will be automatically cloned based on the tool parameters; there is no need to add a Cloud Build step to clone the source.",
+			Description: "Starts a Google Cloud Build build. The source will be automatically cloned based on the tool parameters; there is no need to add a Cloud Build step to clone the source. The build config must satisfy the build policy (allowed builder images, step and timeout limits, no secrets, artifacts only to the configured destinations); violations are returned as an error.",
 		},
-		createCloudBuildTool(*githubToken, *projectID, *region, *subBuildServiceAccount, *subBuildLogsBucket),
+		createCloudBuildTool(*githubToken, *projectID, *region, *subBuildServiceAccount, *subBuildLogsBucket, *runnerTag, policy, dest),
 	)
 
 	mcp.AddTool(server,
@@ -80,10 +117,18 @@ func main() {
 		getCloudBuildTool(*projectID, *region),
 	)
 
+	mcp.AddTool(server,
+		&mcp.Tool{
+			Name:        "wait_for_cloud_build",
+			Description: "Waits for a Google Cloud Build build to finish, polling its status, and returns the final status. If the build did not succeed, also returns the failing step and the end of the logs. Use this instead of calling get_cloud_build repeatedly.",
+		},
+		waitForCloudBuildTool(*projectID, *region, *toolTimeout),
+	)
+
 	mcp.AddTool(server,
 		&mcp.Tool{
 			Name:        "get_cloud_build_logs",
-			Description: "Gets the logs for a Google Cloud Build build.",
+			Description: "Gets the logs for a Google Cloud Build build. The lines can be narrowed to a step, a regular expression, the last N lines or lines that look like errors, and are returned in pages; each line has its line number in the logs. Only the end of very large logs is read.",
 		},
 		getCloudBuildLogsTool(*projectID, *region),
 	)
@@ -96,6 +141,14 @@ func main() {
 		fetchTestOutputTool(*subBuildTestOutputBucket),
 	)
 
+	mcp.AddTool(server,
+		&mcp.Tool{
+			Name:        "get_test_results",
+			Description: "Gets structured test results (totals, per-package counts and durations, and failing tests) from the JUnit XML test output of a build, optionally with a Markdown summary for a PR comment.",
+		},
+		getTestResultsTool(*subBuildTestOutputBucket),
+	)
+
 	mcp.AddTool(server,
 		&mcp.Tool{
 			Name:        "fetch_provenance",
@@ -104,7 +157,28 @@ func main() {
 		fetchProvenanceTool(*projectID, *region),
 	)
 
-	if err := server.Run(context.Background(), &mcp.StdioTransport{}); err != nil {
+	mcp.AddTool(server,
+		&mcp.Tool{
+			Name:        "cancel_cloud_build",
+			Description: "Cancels a Google Cloud Build build created with create_cloud_build, e.g. one that was superseded by a newer build.",
+		},
+		cancelCloudBuildTool(*projectID, *region, *runnerTag),
+	)
+
+	mcp.AddTool(server,
+		&mcp.Tool{
+			Name:        "list_cloud_builds",
+			Description: "Lists the Google Cloud Build builds created with create_cloud_build in this session, with their status.",
+		},
+		listCloudBuildsTool(*projectID, *region, *runnerTag),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = server.Run(ctx, &mcp.StdioTransport{})
+	// Don't leave abandoned sub-builds running.
+	cancelSubBuilds(*projectID, *region, *runnerTag)
+	if err != nil && ctx.Err() == nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"google.golang.org/api/iterator"
+
+	cloudbuildpb "cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
+)
+
+const (
+	maxListedBuilds        = 50
+	shutdownCancelTimeout  = 30 * time.Second
+	generatedRunnerTagSize = 8
+)
+
+// buildTagPattern is the format Cloud Build requires of build tags.
+var buildTagPattern = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+
+// newRunnerTag returns a tag for the sub-builds of this process, for when the
+// runner did not supply one.
+func newRunnerTag() string {
+	b := make([]byte, generatedRunnerTagSize)
+	_, _ = rand.Read(b) // Never returns an error.
+	return "devhelper-" + hex.EncodeToString(b)
+}
+
+type cancelCloudBuildInput struct {
+	BuildID string `json:"build_id" jsonschema:"The Build ID of the build to cancel."`
+}
+
+func (i cancelCloudBuildInput) validate() error {
+	var errs []error
+	if i.BuildID == "" {
+		errs = append(errs, errors.New("build_id is required."))
+	}
+	return errors.Join(errs...)
+}
+
+type cancelCloudBuildOutput struct {
+	BuildID string `json:"build_id" jsonschema:"The Build ID of the build."`
+	Status  string `json:"status" jsonschema:"The build status after the cancellation."`
+}
+
+func cancelCloudBuildTool(projectID, region, runnerTag string) mcp.ToolHandlerFor[cancelCloudBuildInput, cancelCloudBuildOutput] {
+	return func(ctx context.Context, req *mcp.CallToolRequest, input cancelCloudBuildInput) (*mcp.CallToolResult, cancelCloudBuildOutput, error) {
+		if err := input.validate(); err != nil {
+			return nil, cancelCloudBuildOutput{}, err
+		}
+
+		build, err := getCloudBuild(ctx, projectID, region, input.BuildID)
+		if err != nil {
+			return nil, cancelCloudBuildOutput{}, err
+		}
+		if !slices.Contains(build.GetTags(), runnerTag) {
+			return nil, cancelCloudBuildOutput{}, fmt.Errorf("build %s was not created by create_cloud_build in this session", input.BuildID)
+		}
+		if terminalBuildStatus(build.GetStatus()) {
+			// Nothing to cancel.
+			return nil, cancelCloudBuildOutput{BuildID: input.BuildID, Status: build.GetStatus().String()}, nil
+		}
+
+		build, err = cancelCloudBuild(ctx, projectID, region, input.BuildID)
+		if err != nil {
+			return nil, cancelCloudBuildOutput{}, err
+		}
+		return nil, cancelCloudBuildOutput{BuildID: input.BuildID, Status: build.GetStatus().String()}, nil
+	}
+}
+
+type listCloudBuildsInput struct{}
+
+type listedBuild struct {
+	BuildID    string `json:"build_id" jsonschema:"The Build ID of the build."`
+	Status     string `json:"status" jsonschema:"The build status."`
+	CreateTime string `json:"create_time" jsonschema:"When the build was created."`
+	Duration   string `json:"duration,omitempty" jsonschema:"How long the build ran, if it has finished."`
+	Steps      int    `json:"steps" jsonschema:"The number of steps in the build."`
+}
+
+type listCloudBuildsOutput struct {
+	Builds []listedBuild `json:"builds" jsonschema:"The builds created in this session, most recent first."`
+}
+
+func listCloudBuildsTool(projectID, region, runnerTag string) mcp.ToolHandlerFor[listCloudBuildsInput, listCloudBuildsOutput] {
+	return func(ctx context.Context, req *mcp.CallToolRequest, input listCloudBuildsInput) (*mcp.CallToolResult, listCloudBuildsOutput, error) {
+		builds, err := listSubBuilds(ctx, projectID, region, runnerTag)
+		if err != nil {
+			return nil, listCloudBuildsOutput{}, err
+		}
+
+		output := listCloudBuildsOutput{Builds: []listedBuild{}}
+		for _, b := range builds {
+			lb := listedBuild{
+				BuildID:    b.GetId(),
+				Status:     b.GetStatus().String(),
+				CreateTime: b.GetCreateTime().AsTime().Format(time.RFC3339),
+				Steps:      len(b.GetSteps()),
+			}
+			if b.GetStartTime() != nil && b.GetFinishTime() != nil {
+				lb.Duration = b.GetFinishTime().AsTime().Sub(b.GetStartTime().AsTime()).Round(time.Second).String()
+			}
+			output.Builds = append(output.Builds, lb)
+		}
+		return nil, output, nil
+	}
+}
+
+// cancelSubBuilds cancels the sub-builds tagged with runnerTag that are still
+// running. It is called when the server shuts down, so that builds abandoned by
+// the agent do not keep running.
+func cancelSubBuilds(projectID, region, runnerTag string) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownCancelTimeout)
+	defer cancel()
+
+	builds, err := listSubBuilds(ctx, projectID, region, runnerTag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Listing sub-builds to cancel: %v\n", err)
+		return
+	}
+	for _, b := range builds {
+		if terminalBuildStatus(b.GetStatus()) {
+			continue
+		}
+		if _, err := cancelCloudBuild(ctx, projectID, region, b.GetId()); err != nil {
+			fmt.Fprintf(os.Stderr, "Cancelling sub-build %s: %v\n", b.GetId(), err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Cancelled sub-build %s\n", b.GetId())
+	}
+}
+
+// listSubBuilds returns the most recent builds tagged with runnerTag.
+func listSubBuilds(ctx context.Context, projectID, region, runnerTag string) ([]*cloudbuildpb.Build, error) {
+	client, err := cloudBuildClient(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	lbReq := &cloudbuildpb.ListBuildsRequest{
+		Parent:    fmt.Sprintf("projects/%s/locations/%s", projectID, region),
+		ProjectId: projectID,
+		Filter:    fmt.Sprintf("tags=%q", runnerTag),
+		PageSize:  maxListedBuilds,
+	}
+	var builds []*cloudbuildpb.Build
+	it := client.ListBuilds(ctx, lbReq)
+	for len(builds) < maxListedBuilds {
+		b, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing builds: %w", err)
+		}
+		builds = append(builds, b)
+	}
+	return builds, nil
+}
+
+func cancelCloudBuild(ctx context.Context, projectID, region, buildID string) (*cloudbuildpb.Build, error) {
+	client, err := cloudBuildClient(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	cbReq := &cloudbuildpb.CancelBuildRequest{
+		Name:      fmt.Sprintf("projects/%s/locations/%s/builds/%s", projectID, region, buildID),
+		ProjectId: projectID,
+		Id:        buildID,
+	}
+	build, err := client.CancelBuild(ctx, cbReq)
+	if err != nil {
+		return nil, fmt.Errorf("cancelling build %s: %w", buildID, err)
+	}
+	return build, nil
+}
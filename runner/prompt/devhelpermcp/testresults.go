@@ -0,0 +1,275 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	defaultMaxFailures       = 50
+	defaultMaxFailureMessage = 2000
+)
+
+// junitTestSuites is the JUnit XML written by go-junit-report and most other
+// test reporters. Some write a single <testsuite> as the root element instead.
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:""`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Time      string           `xml:"time,attr"`
+	TestCases []junitTestCase  `xml:"testcase"`
+	Suites    []junitTestSuite `xml:"testsuite"` // Nested suites, e.g. from Maven.
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure"`
+	Error     *junitProblem `xml:"error"`
+	Skipped   *junitProblem `xml:"skipped"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// parseJUnit returns the test suites in a JUnit XML report, flattening
+// nested suites.
+func parseJUnit(blob []byte) ([]junitTestSuite, error) {
+	var root junitTestSuites
+	if err := xml.Unmarshal(blob, &root); err != nil {
+		return nil, fmt.Errorf("parsing JUnit XML: %w", err)
+	}
+	var suites []junitTestSuite
+	switch root.XMLName.Local {
+	case "testsuites":
+		suites = root.Suites
+	case "testsuite":
+		var suite junitTestSuite
+		if err := xml.Unmarshal(blob, &suite); err != nil {
+			return nil, fmt.Errorf("parsing JUnit XML: %w", err)
+		}
+		suites = []junitTestSuite{suite}
+	default:
+		return nil, fmt.Errorf("parsing JUnit XML: unexpected root element <%s>", root.XMLName.Local)
+	}
+
+	var flat []junitTestSuite
+	var walk func([]junitTestSuite)
+	walk = func(ss []junitTestSuite) {
+		for _, s := range ss {
+			flat = append(flat, s)
+			walk(s.Suites)
+		}
+	}
+	walk(suites)
+	return flat, nil
+}
+
+type getTestResultsInput struct {
+	BuildID           string `json:"build_id,omitempty" jsonschema:"The Build ID of the build that wrote <BUILD_ID>_test_log.xml. Either this or test_output_filename is required."`
+	Filename          string `json:"test_output_filename,omitempty" jsonschema:"The test output filename, if it is not <BUILD_ID>_test_log.xml."`
+	MaxFailures       int    `json:"max_failures,omitempty" jsonschema:"The maximum number of failing tests to return. Defaults to 50."`
+	MaxFailureMessage int    `json:"max_failure_message,omitempty" jsonschema:"The maximum length of each failure message, in characters. Defaults to 2000."`
+	Markdown          bool   `json:"markdown,omitempty" jsonschema:"Also render a Markdown summary, suitable for a PR comment."`
+}
+
+func (i getTestResultsInput) validate() error {
+	var errs []error
+	if i.BuildID == "" && i.Filename == "" {
+		errs = append(errs, errors.New("build_id or test_output_filename is required."))
+	}
+	if i.MaxFailures < 0 {
+		errs = append(errs, errors.New("max_failures must not be negative."))
+	}
+	if i.MaxFailureMessage < 0 {
+		errs = append(errs, errors.New("max_failure_message must not be negative."))
+	}
+	return errors.Join(errs...)
+}
+
+type testCounts struct {
+	Tests           int     `json:"tests" jsonschema:"The number of tests."`
+	Passed          int     `json:"passed" jsonschema:"The number of tests that passed."`
+	Failed          int     `json:"failed" jsonschema:"The number of tests that failed or errored."`
+	Skipped         int     `json:"skipped" jsonschema:"The number of tests that were skipped."`
+	DurationSeconds float64 `json:"duration_seconds" jsonschema:"The time taken, in seconds."`
+}
+
+type packageResult struct {
+	Name string `json:"name" jsonschema:"The package (test suite) name."`
+	testCounts
+}
+
+type testFailure struct {
+	Package string `json:"package" jsonschema:"The package (test suite) of the test."`
+	Name    string `json:"name" jsonschema:"The test name."`
+	Message string `json:"message" jsonschema:"The failure message and output, truncated."`
+}
+
+type getTestResultsOutput struct {
+	Totals          testCounts      `json:"totals" jsonschema:"The totals over all packages."`
+	Packages        []packageResult `json:"packages" jsonschema:"The results of each package."`
+	Failures        []testFailure   `json:"failures" jsonschema:"The failing tests."`
+	FailuresOmitted int             `json:"failures_omitted,omitempty" jsonschema:"The number of failing tests not included, beyond max_failures."`
+	Markdown        string          `json:"markdown,omitempty" jsonschema:"A Markdown summary, if requested."`
+}
+
+func getTestResultsTool(subBuildTestOutputBucket string) mcp.ToolHandlerFor[getTestResultsInput, getTestResultsOutput] {
+	return func(ctx context.Context, req *mcp.CallToolRequest, input getTestResultsInput) (*mcp.CallToolResult, getTestResultsOutput, error) {
+		if err := input.validate(); err != nil {
+			return nil, getTestResultsOutput{}, err
+		}
+		filename := cmp.Or(input.Filename, input.BuildID+"_test_log.xml")
+
+		blob, err := readTestOutput(ctx, subBuildTestOutputBucket, filename)
+		if err != nil {
+			return nil, getTestResultsOutput{}, err
+		}
+		suites, err := parseJUnit(blob)
+		if err != nil {
+			return nil, getTestResultsOutput{}, fmt.Errorf("%s: %w", filename, err)
+		}
+
+		output := summarizeTests(suites, cmp.Or(input.MaxFailures, defaultMaxFailures), cmp.Or(input.MaxFailureMessage, defaultMaxFailureMessage))
+		if input.Markdown {
+			output.Markdown = testResultsMarkdown(output)
+		}
+		return nil, output, nil
+	}
+}
+
+func summarizeTests(suites []junitTestSuite, maxFailures, maxMessage int) getTestResultsOutput {
+	output := getTestResultsOutput{Packages: []packageResult{}, Failures: []testFailure{}}
+	for _, s := range suites {
+		if len(s.TestCases) == 0 {
+			continue // Only holds nested suites, or a package without tests.
+		}
+		p := packageResult{Name: s.Name}
+		p.DurationSeconds = parseSeconds(s.Time)
+		for _, tc := range s.TestCases {
+			p.Tests++
+			problem := cmp.Or(tc.Failure, tc.Error)
+			switch {
+			case problem != nil:
+				p.Failed++
+				if len(output.Failures) >= maxFailures {
+					output.FailuresOmitted++
+					continue
+				}
+				output.Failures = append(output.Failures, testFailure{
+					Package: cmp.Or(s.Name, tc.ClassName),
+					Name:    tc.Name,
+					Message: truncateMessage(maxMessage, problem.describe()),
+				})
+			case tc.Skipped != nil:
+				p.Skipped++
+			default:
+				p.Passed++
+			}
+		}
+		output.Packages = append(output.Packages, p)
+
+		output.Totals.Tests += p.Tests
+		output.Totals.Passed += p.Passed
+		output.Totals.Failed += p.Failed
+		output.Totals.Skipped += p.Skipped
+		output.Totals.DurationSeconds += p.DurationSeconds
+	}
+	return output
+}
+
+// describe returns the message of a failure followed by its output.
+func (p *junitProblem) describe() string {
+	text := strings.TrimSpace(p.Text)
+	msg := strings.TrimSpace(p.Message)
+	switch {
+	case msg == "" || msg == "Failed" || strings.Contains(text, msg):
+		// go-junit-report always uses "Failed" as the message.
+		return text
+	case text == "":
+		return msg
+	default:
+		return msg + "\n" + text
+	}
+}
+
+func parseSeconds(s string) float64 {
+	f, _ := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+	return f
+}
+
+// truncateMessage shortens s to at most n runes, keeping its end, which is
+// usually where a test's output explains the failure.
+func truncateMessage(n int, s string) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return fmt.Sprintf("[... %d characters truncated]\n%s", len(r)-n, string(r[len(r)-n:]))
+}
+
+var markdownCellEscaper = strings.NewReplacer("|", `\|`, "\n", " ", "\r", "")
+
+func testResultsMarkdown(output getTestResultsOutput) string {
+	var b strings.Builder
+	t := output.Totals
+	fmt.Fprintf(&b, "**%d tests: %d passed, %d failed, %d skipped** in %.1fs\n\n", t.Tests, t.Passed, t.Failed, t.Skipped, t.DurationSeconds)
+	b.WriteString("| Package | Tests | Passed | Failed | Skipped | Time |\n")
+	b.WriteString("|---|---:|---:|---:|---:|---:|\n")
+	for _, p := range output.Packages {
+		status := "✅"
+		if p.Failed > 0 {
+			status = "❌"
+		}
+		fmt.Fprintf(&b, "| %s `%s` | %d | %d | %d | %d | %.1fs |\n", status, markdownCellEscaper.Replace(p.Name), p.Tests, p.Passed, p.Failed, p.Skipped, p.DurationSeconds)
+	}
+	if len(output.Failures) > 0 {
+		b.WriteString("\n**Failing tests**\n\n")
+		for _, f := range output.Failures {
+			fmt.Fprintf(&b, "- `%s` in `%s`\n", f.Name, f.Package)
+		}
+		if output.FailuresOmitted > 0 {
+			fmt.Fprintf(&b, "- ... and %d more\n", output.FailuresOmitted)
+		}
+	}
+	return b.String()
+}
+
+// readTestOutput reads a test output object from the test output bucket.
+func readTestOutput(ctx context.Context, subBuildTestOutputBucket, object string) ([]byte, error) {
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	defer storageClient.Close()
+
+	bucket := strings.TrimPrefix(subBuildTestOutputBucket, "gs://")
+
+	rc, err := storageClient.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting test output object (bucket %q object %q): %w", bucket, object, err)
+	}
+	defer rc.Close()
+
+	blob, err := io.ReadAll(io.LimitReader(rc, maxTestOutput))
+	if err != nil {
+		return nil, fmt.Errorf("reading test output object (bucket %q object %q): %w", bucket, object, err)
+	}
+	return blob, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseJUnit(t *testing.T) {
+	tests := []struct {
+		name    string
+		xml     string
+		want    []string // Suite names, flattened.
+		wantErr bool
+	}{
+		{
+			name: "testsuites",
+			xml: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="example.com/a" tests="1"><testcase name="TestA"/></testsuite>
+  <testsuite name="example.com/b" tests="1"><testcase name="TestB"/></testsuite>
+</testsuites>`,
+			want: []string{"example.com/a", "example.com/b"},
+		},
+		{
+			name: "single testsuite root",
+			xml:  `<testsuite name="pytest" tests="1"><testcase name="test_a"/></testsuite>`,
+			want: []string{"pytest"},
+		},
+		{
+			name: "nested suites",
+			xml: `<testsuites><testsuite name="root">
+  <testsuite name="child"><testcase name="a"/><testsuite name="grandchild"><testcase name="b"/></testsuite></testsuite>
+</testsuite></testsuites>`,
+			want: []string{"root", "child", "grandchild"},
+		},
+		{name: "empty testsuites", xml: `<testsuites/>`},
+		{name: "other root", xml: `<html><body/></html>`, wantErr: true},
+		{name: "not XML", xml: `FAIL example.com/a`, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			suites, err := parseJUnit([]byte(tc.xml))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseJUnit() error = %v, want error %t", err, tc.wantErr)
+			}
+			var got []string
+			for _, s := range suites {
+				got = append(got, s.Name)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("parseJUnit() suites = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeTests(t *testing.T) {
+	const report = `<testsuites>
+  <testsuite name="example.com/a" time="1.5">
+    <testcase name="TestPass" time="0.1"/>
+    <testcase name="TestFail" time="0.2"><failure message="Failed">    client_test.go:12: got 1, want 2
+</failure></testcase>
+    <testcase name="TestSkip"><skipped message="short mode"/></testcase>
+  </testsuite>
+  <testsuite name="example.com/b" time="1,000.25">
+    <testcase name="TestError" classname="b"><error message="panic: boom" type="panic">goroutine 1 [running]:</error></testcase>
+    <testcase name="TestMessageOnly"><failure message="expected true"/></testcase>
+  </testsuite>
+  <testsuite name="example.com/empty" time="0"/>
+</testsuites>`
+	suites, err := parseJUnit([]byte(report))
+	if err != nil {
+		t.Fatalf("parseJUnit() = %v", err)
+	}
+
+	t.Run("counts", func(t *testing.T) {
+		got := summarizeTests(suites, 10, 100)
+		want := testCounts{Tests: 5, Passed: 1, Failed: 3, Skipped: 1, DurationSeconds: 1001.75}
+		if got.Totals != want {
+			t.Errorf("Totals = %+v, want %+v", got.Totals, want)
+		}
+		var names []string
+		for _, p := range got.Packages {
+			names = append(names, p.Name)
+		}
+		if want := []string{"example.com/a", "example.com/b"}; !slices.Equal(names, want) {
+			t.Errorf("Packages = %q, want %q", names, want)
+		}
+		if a := got.Packages[0].testCounts; a != (testCounts{Tests: 3, Passed: 1, Failed: 1, Skipped: 1, DurationSeconds: 1.5}) {
+			t.Errorf("example.com/a = %+v", a)
+		}
+	})
+
+	t.Run("failure messages", func(t *testing.T) {
+		got := summarizeTests(suites, 10, 100)
+		want := []testFailure{
+			{Package: "example.com/a", Name: "TestFail", Message: "client_test.go:12: got 1, want 2"},
+			{Package: "example.com/b", Name: "TestError", Message: "panic: boom\ngoroutine 1 [running]:"},
+			{Package: "example.com/b", Name: "TestMessageOnly", Message: "expected true"},
+		}
+		if !slices.Equal(got.Failures, want) {
+			t.Errorf("Failures = %q, want %q", got.Failures, want)
+		}
+		if got.FailuresOmitted != 0 {
+			t.Errorf("FailuresOmitted = %d, want 0", got.FailuresOmitted)
+		}
+	})
+
+	t.Run("max failures", func(t *testing.T) {
+		got := summarizeTests(suites, 1, 100)
+		if len(got.Failures) != 1 || got.FailuresOmitted != 2 || got.Totals.Failed != 3 {
+			t.Errorf("got %d failures, %d omitted, %d failed; want 1, 2, 3", len(got.Failures), got.FailuresOmitted, got.Totals.Failed)
+		}
+	})
+
+	t.Run("message truncated to its end", func(t *testing.T) {
+		got := summarizeTests(suites, 1, 10)
+		if msg, want := got.Failures[0].Message, "[... 22 characters truncated]\n 1, want 2"; msg != want {
+			t.Errorf("Message = %q, want %q", msg, want)
+		}
+	})
+
+	t.Run("no tests", func(t *testing.T) {
+		got := summarizeTests(nil, 10, 100)
+		if got.Packages == nil || got.Failures == nil || got.Totals != (testCounts{}) {
+			t.Errorf("summarizeTests(nil) = %+v, want empty non-nil lists and zero totals", got)
+		}
+	})
+}
+
+func TestProblemDescribe(t *testing.T) {
+	tests := []struct {
+		problem junitProblem
+		want    string
+	}{
+		{problem: junitProblem{Message: "Failed", Text: "\n  out\n"}, want: "out"},
+		{problem: junitProblem{Message: "boom"}, want: "boom"},
+		{problem: junitProblem{Message: "boom", Text: "panic: boom"}, want: "panic: boom"},
+		{problem: junitProblem{Message: "assertion", Text: "trace"}, want: "assertion\ntrace"},
+		{problem: junitProblem{}, want: ""},
+	}
+	for _, tc := range tests {
+		if got := tc.problem.describe(); got != tc.want {
+			t.Errorf("%+v.describe() = %q, want %q", tc.problem, got, tc.want)
+		}
+	}
+}
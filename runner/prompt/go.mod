@@ -10,6 +10,7 @@ require (
 	github.com/modelcontextprotocol/go-sdk v1.0.0
 	google.golang.org/api v0.256.0
 	google.golang.org/genproto v0.0.0-20251111163417-95abcf5c77ba
+	google.golang.org/grpc v1.76.0
 	google.golang.org/protobuf v1.36.10
 )
 
@@ -62,5 +63,4 @@ require (
 	golang.org/x/time v0.14.0 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20251103181224-f26f9409b101 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20251103181224-f26f9409b101 // indirect
-	google.golang.org/grpc v1.76.0 // indirect
 )
@@ -6,13 +6,31 @@ resource "google_cloud_run_v2_service" "default" {
   template {
     service_account = google_service_account.default["pillar-service"].email
     scaling {
-      max_instance_count = 10
+      # Jobs, deliveries and pending batches are held in memory, so a single
+      # instance must see every webhook and admin request.
+      max_instance_count = 1
     }
     containers {
       image = ko_build.pillar_service.image_ref
       ports {
         container_port = 8080
       }
+      resources {
+        # Debounced pull_request runs fire after the webhook has returned.
+        cpu_idle = false
+      }
+      startup_probe {
+        http_get {
+          path = "/readyz"
+        }
+        period_seconds    = 5
+        failure_threshold = 6
+      }
+      liveness_probe {
+        http_get {
+          path = "/healthz"
+        }
+      }
       env {
         name  = "PROJECT_ID"
         value = var.project_id